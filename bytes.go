@@ -0,0 +1,101 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"unsafe"
+)
+
+var extBufsName = "\xFFextBufs\x00"
+
+type externalBytes struct {
+	b []byte
+}
+
+// ExternalBytes makes b exposed to JS as an Uint8Array sharing the memory of b
+// without copying. b is pinned during the call (Eval, CallFunc, or calling a
+// bound function) in which it is pushed. After the call returns, the Uint8Array
+// is detached from b (reading it gets 0), so JS must not keep it. Changes made
+// by JS are visible to Go.
+func ExternalBytes(b []byte) interface{} {
+	return &externalBytes{b: b}
+}
+
+// pushBytes pushes a copy of b as an Uint8Array.
+func pushBytes(ctx *C.duk_context, b []byte) {
+	p := C.duk_push_buffer_raw(ctx, C.duk_size_t(len(b)), C.DUK_BUF_FLAG_NOZERO) // [ buf ]
+	if len(b) > 0 {
+		copy(unsafe.Slice((*byte)(p), len(b)), b)
+	}
+	C.duk_push_buffer_object(ctx, -1, 0, C.duk_size_t(len(b)), C.DUK_BUFOBJ_UINT8ARRAY) // [ buf Uint8Array ]
+	C.duk_remove(ctx, -2) // [ Uint8Array ]
+}
+
+func pushExternalBytes(ctx *C.duk_context, b []byte) {
+	if len(b) == 0 {
+		pushBytes(ctx, b)
+		return
+	}
+
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	state.lock.Lock()
+	state.pinner.Pin(&b[0])
+	state.lock.Unlock()
+
+	C.duk_push_buffer_raw(ctx, 0, C.DUK_BUF_FLAG_DYNAMIC|C.DUK_BUF_FLAG_EXTERNAL) // [ buf ]
+	C.duk_config_buffer(ctx, -1, unsafe.Pointer(&b[0]), C.duk_size_t(len(b)))
+
+	// remember the external buffer, to be detached after the call returns.
+	var name *C.char
+	getStrPtr(&extBufsName, &name)
+	C.duk_push_thread_stash(ctx, ctx) // [ buf stash ], not shared by the contexts of a heap
+	if C.duk_get_prop_string(ctx, -1, name) == 0 { // [ buf stash extBufs ]
+		C.duk_pop(ctx) // [ buf stash ]
+		C.duk_push_array(ctx) // [ buf stash extBufs ]
+		C.duk_dup(ctx, -1) // [ buf stash extBufs extBufs ]
+		C.duk_put_prop_string(ctx, -3, name) // [ buf stash extBufs ] with stash[extBufsName] = extBufs
+	}
+	n := C.duk_get_length(ctx, -1)
+	C.duk_dup(ctx, -3) // [ buf stash extBufs buf ]
+	C.duk_put_prop_index(ctx, -2, C.duk_uarridx_t(n)) // [ buf stash extBufs ] with extBufs[n] = buf
+	C.duk_pop_n(ctx, 2) // [ buf ]
+
+	C.duk_push_buffer_object(ctx, -1, 0, C.duk_size_t(len(b)), C.DUK_BUFOBJ_UINT8ARRAY) // [ buf Uint8Array ]
+	C.duk_remove(ctx, -2) // [ Uint8Array ]
+}
+
+// enterCall and leaveCall surround every call from Go to JS, external buffers
+// are detached and unpinned when the outermost call returns.
+func enterCall(ctx *C.duk_context) {
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.callDepth += 1
+}
+
+func leaveCall(ctx *C.duk_context) {
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if state.callDepth -= 1; state.callDepth > 0 {
+		return
+	}
+
+	var name *C.char
+	getStrPtr(&extBufsName, &name)
+	C.duk_push_thread_stash(ctx, ctx) // [ stash ]
+	if C.duk_get_prop_string(ctx, -1, name) != 0 { // [ stash extBufs ]
+		n := int(C.duk_get_length(ctx, -1))
+		for i:=0; i<n; i++ {
+			C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(i)) // [ stash extBufs buf ]
+			C.duk_config_buffer(ctx, -1, nil, 0)
+			C.duk_pop(ctx) // [ stash extBufs ]
+		}
+		C.duk_pop(ctx) // [ stash ]
+		C.duk_del_prop_string(ctx, -1, name) // [ stash ]
+	} else {
+		C.duk_pop(ctx) // [ stash ]
+	}
+	C.duk_pop(ctx) // [ ]
+	state.pinner.Unpin()
+}
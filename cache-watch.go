@@ -0,0 +1,176 @@
+package djs
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+type FnOnReload func(path string, err error)
+
+type WatchConfig struct {
+	PollInterval time.Duration // interval of polling if inotify is not available, default 1s
+	Recompile    bool          // recompile the changed scripts at once, otherwise they are reloaded when loaded next time
+	OnReload     FnOnReload    // called after a script is reloaded. if err is not nil, the previous version is still served
+}
+
+// Watch makes the cache watch the script files and the module files instead
+// of checking them every time LoadFile() is called.
+func (c *Cache) Watch(conf WatchConfig) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.watcher != nil {
+		err = fmt.Errorf("cache is being watched")
+		return
+	}
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = time.Second
+	}
+	c.watchConf = &conf
+	c.watcher = newFileWatcher(conf.PollInterval, c.fileChanged)
+	c.watched = make(map[string]map[*jsCtx]struct{})
+	for _, jsC := range c.entries {
+		c.watchDeps(jsC)
+	}
+	return
+}
+
+func (c *Cache) StopWatching() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.watcher == nil {
+		return
+	}
+	c.watcher.close()
+	c.watcher = nil
+	c.watchConf = nil
+	c.watched = nil
+}
+
+func (c *Cache) SetOnReload(onReload FnOnReload) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.watchConf != nil {
+		c.watchConf.OnReload = onReload
+	}
+}
+
+// called with c.lock locked.
+func (c *Cache) watchDeps(jsC *jsCtx) {
+	if c.watcher == nil {
+		return
+	}
+	// files still depended on are added before the others are removed, so their watches are kept.
+	watching := make(map[string]struct{})
+	for path, _ := range jsC.deps {
+		absPath, e := filepath.Abs(path)
+		if e != nil {
+			continue
+		}
+		if e = c.watcher.add(absPath); e != nil {
+			continue
+		}
+		watching[absPath] = struct{}{}
+		entries, ok := c.watched[absPath]
+		if !ok {
+			entries = make(map[*jsCtx]struct{})
+			c.watched[absPath] = entries
+		}
+		entries[jsC] = struct{}{}
+	}
+	c.unwatchDeps(jsC, watching)
+}
+
+// unwatchDeps removes jsC from the entries of the watched files except the ones in keep,
+// a file is removed from the watcher when no entry depends on it. called with c.lock locked.
+func (c *Cache) unwatchDeps(jsC *jsCtx, keep map[string]struct{}) {
+	for path, entries := range c.watched {
+		if _, ok := keep[path]; ok {
+			continue
+		}
+		if _, ok := entries[jsC]; !ok {
+			continue
+		}
+		delete(entries, jsC)
+		if len(entries) == 0 {
+			delete(c.watched, path)
+			c.watcher.remove(path)
+		}
+	}
+}
+
+// called with c.lock locked.
+func (c *Cache) reportReload(path string, err error) {
+	if c.watchConf != nil && c.watchConf.OnReload != nil {
+		go c.watchConf.OnReload(path, err)
+	}
+}
+
+// called by the watcher
+func (c *Cache) fileChanged(absPath string) {
+	c.lock.Lock()
+	changed := []*jsCtx{}
+	for jsC, _ := range c.watched[absPath] {
+		if depsChanged(jsC.deps) {
+			changed = append(changed, jsC)
+		}
+	}
+	recompile := c.watchConf != nil && c.watchConf.Recompile
+	for _, jsC := range changed {
+		if jsC.reloading {
+			jsC.pending = true // the reloading version may be out of date, see finishReload()
+		} else if !recompile {
+			jsC.stale = true
+		}
+	}
+	c.lock.Unlock()
+
+	if recompile {
+		for _, jsC := range changed {
+			c.recompile(jsC)
+		}
+	}
+}
+
+func (c *Cache) recompile(jsC *jsCtx) {
+	c.lock.Lock()
+	if jsC.reloading {
+		jsC.pending = true
+	}
+	if jsC.reloading || c.entries[jsC.key] != jsC {
+		c.lock.Unlock()
+		return
+	}
+	c.stats.Reloads += 1
+	jsC.reloading = true
+	path, vars, fromGlobalHeap, oldDeps := jsC.path, jsC.vars, jsC.fromGlobalHeap, jsC.deps
+	c.lock.Unlock()
+
+	ctx, deps, err := loadJSContext(path, vars, fromGlobalHeap, oldDeps)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	defer c.finishReload(jsC)
+	if c.entries[jsC.key] != jsC {
+		return
+	}
+	if err == nil {
+		jsC.jsvm = ctx
+		jsC.deps = deps
+		jsC.loadedAt = time.Now()
+		c.watchDeps(jsC)
+	}
+	c.reportReload(path, err)
+}
+
+// finishReload ends reloading jsC, which stays stale if a dependent file changed
+// while reloading, and is recompiled again in the Recompile mode. called with c.lock locked.
+func (c *Cache) finishReload(jsC *jsCtx) {
+	jsC.reloading = false
+	jsC.stale, jsC.pending = jsC.pending, false
+	if jsC.stale && c.watchConf != nil && c.watchConf.Recompile && c.entries[jsC.key] == jsC {
+		go c.recompile(jsC)
+	}
+}
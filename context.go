@@ -6,15 +6,41 @@ package djs
 #include "duk_console.h"
 #include "duk_print_alert.h"
 #include "duk_module_duktape.h"
-static duk_context *createContext() {
-	return duk_create_heap_default();
-}
 static const char *getCString(duk_context *ctx, duk_idx_t idx) {
 	return duk_safe_to_string(ctx, idx);
 }
 static duk_int_t pEval(duk_context *ctx, const char *src, duk_size_t len) {
 	return duk_peval_lstring(ctx, src, len);
 }
+static duk_ret_t unsafeDelProp(duk_context *ctx, void *udata) {
+	(void)udata;
+	// [ ... obj key ], the stack is not a new frame
+	duk_del_prop(ctx, -2);
+	return 0;
+}
+static duk_ret_t unsafeUndefProp(duk_context *ctx, void *udata) {
+	(void)udata;
+	// [ ... obj key ]
+	duk_push_undefined(ctx);
+	duk_put_prop(ctx, -3);
+	return 0;
+}
+static void clearProp(duk_context *ctx, duk_idx_t objIdx) {
+	// [ ... key ] -> [ ... ]
+	// delete obj[key], or set it to undefined if not configurable.
+	duk_idx_t oIdx = duk_normalize_index(ctx, objIdx);
+	duk_dup(ctx, oIdx);
+	duk_dup(ctx, -2);
+	duk_int_t rc = duk_safe_call(ctx, unsafeDelProp, NULL, 2, 1);
+	duk_pop(ctx);
+	if (rc != DUK_EXEC_SUCCESS) {
+		duk_dup(ctx, oIdx);
+		duk_dup(ctx, -2);
+		duk_safe_call(ctx, unsafeUndefProp, NULL, 2, 1);
+		duk_pop(ctx);
+	}
+	duk_pop(ctx);
+}
 */
 import "C"
 import (
@@ -22,75 +48,120 @@ import (
 	"unsafe"
 	"fmt"
 	"os"
-	"sync"
 	"runtime"
+	"time"
 )
 
-var (
-	globalHeap *C.duk_context
-	globalMu = &sync.Mutex{}
-)
-
-func init() {
-	globalHeap = C.createContext()
-	if globalHeap == nil {
-		panic("failed to init duktape heap")
-	}
-	loadPreludeModules(globalHeap)
-}
-
-/*
-func DestroyJsHeap() {
-	globalMu.Lock()
-	defer globalMu.Unlock()
-
-	if globalHeap != nil {
-		C.duk_destroy_heap(globalHeap)
-		globalHeap = nil
-	}
-}*/
-
 type JsContext struct {
 	c *C.duk_context
-	mu *sync.Mutex
-	withGlobalHeap bool
+	state *ctxState
+	mu *ctxLock
+	heap *Heap // nil if the context is with its own heap
+	mem *heapMem // memory of the heap
+}
+
+// Options of creating a context.
+type Options struct {
+	WithoutGlobalHeap bool     // create a context with its own heap, or else it shares the globals of the default heap with other contexts
+	Sandbox           *Sandbox // restrict the capabilities of scripts, a sandboxed context is always with its own heap
+	ReadOnly          bool     // Go values can not be altered by setting or deleting properties, like wrapped by ReadOnly()
+	CopyValues        bool     // Go maps, structs, slices and arrays are copied to JS, like wrapped by Copy()
+	Int64Mode         Int64Mode // how to push integers out of the safe range of JS numbers
+	TimeLocation      *time.Location // location of time.Time converted from Date, default is time.Local
+	BytesAsString     bool     // []byte is pushed as string instead of Uint8Array
+	UseMarshalers     bool     // values implementing json.Marshaler or encoding.TextMarshaler are pushed as their JSON or text
+	StrictConversion  bool     // undefined and null in results are converted to Undefined and Null instead of nil
+	MaxDepth          int      // max nesting depth of JS objects converted to Go, 0 means 1000
+	MaxElements       int      // max number of array elements and object properties in a JS value converted to Go, 0 means no limit
+	CycleMode         CycleMode // how a JS object referring to itself is converted to Go
+	IgnoreGetters     bool     // accessor properties of JS objects are skipped instead of calling the getters
+	SymbolKeys        bool     // properties with Symbol keys are converted, with keys like "Symbol(description)"
+	DebugPins         bool     // record where every Go value referred by a JS proxy is pushed from, reported by Stats()
 }
 
+// NewContext creates a context with its own heap if withoutGlobalHeap is true, or else a
+// context on the default heap, which shares the global object with all such contexts.
 func NewContext(withoutGlobalHeap ...bool) (*JsContext, error) {
-	var ctx *C.duk_context
-
-	withGlobalHeap := !(len(withoutGlobalHeap) > 0 && withoutGlobalHeap[0])
-	if withGlobalHeap {
-		globalMu.Lock()
-		defer globalMu.Unlock()
-		C.duk_push_thread_raw(globalHeap, 0)
-		ctx = C.duk_get_context(globalHeap, -1)
-	} else {
-		ctx = C.createContext()
+	return NewContextWithOptions(&Options{
+		WithoutGlobalHeap: len(withoutGlobalHeap) > 0 && withoutGlobalHeap[0],
+	})
+}
+
+func NewContextWithOptions(options *Options) (*JsContext, error) {
+	if options == nil {
+		options = &Options{}
 	}
+	if !options.WithoutGlobalHeap && options.Sandbox == nil {
+		return defaultHeap.NewContextWithOptions(options)
+	}
+
+	ctx, mem := createHeap()
 	if ctx == (*C.duk_context)(unsafe.Pointer(nil)) {
 		return nil, fmt.Errorf("failed to create context")
 	}
+	loadPreludeModules(ctx)
+	return newJsContext(ctx, nil, mem, &ctxLock{}, options)
+}
 
-	if !withGlobalHeap {
-		loadPreludeModules(ctx)
+func newJsContext(ctx *C.duk_context, heap *Heap, mem *heapMem, lock *ctxLock, options *Options) (*JsContext, error) {
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	state.options = *options
+	state.ctxLock = lock
+	state.heap = uintptr(unsafe.Pointer(ctx))
+	if heap != nil {
+		state.heap = uintptr(unsafe.Pointer(heap.c))
 	}
-	registerGoProxyHandlers(ctx)
 	c := &JsContext {
 		c: ctx,
-		mu: &sync.Mutex{},
-		withGlobalHeap: withGlobalHeap,
+		state: state,
+		mu: lock,
+		heap: heap,
+		mem: mem,
 	}
+	lock.Lock()
+	registerGoProxyHandlers(ctx)
+	lock.Unlock()
 	runtime.SetFinalizer(c, freeJsContext)
+
+	if err := c.registerClassHelper(); err != nil {
+		return nil, err
+	}
+	if options.Int64Mode == Int64AsBoxed {
+		if err := c.registerInt64Class(); err != nil {
+			return nil, err
+		}
+	}
+	if options.Sandbox != nil {
+		if err := c.applySandbox(options.Sandbox); err != nil {
+			return nil, err
+		}
+	}
 	return c, nil
 }
 
+// free frees the context at once instead of waiting for the finalizer, it must not be used after freeing.
+func (ctx *JsContext) free() {
+	runtime.SetFinalizer(ctx, nil)
+	if ctx.heap != nil {
+		ctx.heap.freeThread(ctx.c, ctx.state)
+		return
+	}
+	freeJsContext(ctx)
+}
+
+// freeJsContext is the finalizer of JsContext. The thread of a context of a Heap is queued
+// without locking the heap, and released the next time the heap is locked.
 func freeJsContext(ctx *JsContext) {
 	c := ctx.c
-	if (!ctx.withGlobalHeap) {
-		C.duk_destroy_heap(c)
+	if ctx.heap != nil {
+		ctx.heap.addReleasedThread(c, ctx.state)
+	} else {
+		ctx.mu.Lock()
+		ctx.state.closed = true // JsFunction-s are not usable
+		destroyHeap(c, ctx.mem)
+		ctx.mu.Unlock()
+		delCtxStateIf(uintptr(unsafe.Pointer(c)), ctx.state)
 	}
-	delPtrStore((uintptr(unsafe.Pointer(c))))
 	fmt.Printf("context freed\n")
 }
 
@@ -101,6 +172,51 @@ func loadPreludeModules(ctx *C.duk_context) {
 	setModSearch(ctx)
 }
 
+// lock locks the context before using the duktape context, it fails if the
+// context is freed or its heap is closed.
+func (ctx *JsContext) lock() (err error) {
+	ctx.mu.Lock()
+	if err = ctx.enter(); err != nil {
+		ctx.mu.Unlock()
+	}
+	return
+}
+
+func (ctx *JsContext) unlock() {
+	ctx.leave()
+	ctx.mu.Unlock()
+}
+
+// enter marks ctx as the locked context, so that JsFunction-s converted from JS keep it
+// from being garbage collected, and removes the JS functions and the threads released by finalizers.
+// ctx.mu must be locked.
+func (ctx *JsContext) enter() (err error) {
+	state := ctx.state
+	if state.closed {
+		err = fmt.Errorf("context is freed or its heap is closed")
+		return
+	}
+	state.jsCtx = ctx
+	state.entered += 1
+	releaseFuncs(ctx.c, state.takeReleasedFuncs())
+	if ctx.heap != nil {
+		ctx.heap.releaseThreads()
+	}
+	return
+}
+
+func (ctx *JsContext) leave() {
+	state := ctx.state
+	if state.entered -= 1; state.entered == 0 {
+		state.jsCtx = nil
+	}
+}
+
+// modFiles returns the files loaded by require() with their module ids and the hash of their content.
+func (ctx *JsContext) modFiles() map[string]modFile {
+	return getCtxState(uintptr(unsafe.Pointer(ctx.c))).getModFiles()
+}
+
 func (ctx *JsContext) Eval(script string, env map[string]interface{}) (res interface{}, err error) {
 	var cstr *C.char
 	var length C.int
@@ -122,20 +238,107 @@ func (ctx *JsContext) EvalFile(scriptFile string, env map[string]interface{}) (r
 }
 
 func (ctx *JsContext) eval(script *C.char, scriptLen C.int, env map[string]interface{}) (res interface{}, err error) {
-	ctx.mu.Lock()
-	defer ctx.mu.Unlock()
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
 
 	c := ctx.c
-	setEnv(c, env)
+	enterCall(c)
+	defer leaveCall(c)
+	if err = evalOnStack(c, script, scriptLen, env); err != nil {
+		return
+	}
+
+	defer C.duk_pop(c)
+	return fromJsResult(c)
+}
+
+// evalOnStack leaves the result on the top of the stack if no error occurs.
+func evalOnStack(c *C.duk_context, script *C.char, scriptLen C.int, env map[string]interface{}) (err error) {
+	if err = setEnv(c, env); err != nil {
+		return
+	}
 
 	if C.pEval(c, script, C.size_t(scriptLen)) != 0 { // [ result ]
-		estr := C.getCString(c, -1)
+		err = fmt.Errorf("%s", C.GoString(C.getCString(c, -1)))
 		C.duk_pop(c)
-		err = fmt.Errorf("%s", C.GoString(estr))
 		return
 	}
+	return
+}
+
+// EvalBorrow is Eval without copying the strings and []byte in the result, they refer to
+// the memory of duktape which is valid only until fn returns, so fn must not keep them,
+// and fn must not call any method of ctx.
+func (ctx *JsContext) EvalBorrow(script string, env map[string]interface{}, fn func(res interface{}) error) (err error) {
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	enterCall(c)
+	defer leaveCall(c)
+	var cstr *C.char
+	var length C.int
+	getStrPtrLen(&script, &cstr, &length)
+	if err = evalOnStack(c, cstr, length, env); err != nil {
+		return
+	}
+	defer C.duk_pop(c) // after fn returns
+
+	return borrowResult(c, fn)
+}
+
+func borrowResult(c *C.duk_context, fn func(res interface{}) error) (err error) {
+	setBorrowing(c, true)
+	res, e := fromJsResult(c)
+	setBorrowing(c, false)
+	if e != nil {
+		err = e
+		return
+	}
+	return fn(res)
+}
+
+// callScriptFunc evaluates script which results a function, and calls it with
+// this and args in protected mode.
+func (ctx *JsContext) callScriptFunc(script string, this interface{}, args ...interface{}) (res interface{}, err error) {
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
 
+	c := ctx.c
+	enterCall(c)
+	defer leaveCall(c)
+	var cstr *C.char
+	var length C.int
+	getStrPtrLen(&script, &cstr, &length)
+	if C.pEval(c, cstr, C.size_t(length)) != 0 { // [ func ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(c, -1)))
+		C.duk_pop(c)
+		return
+	}
+	if this == nil {
+		C.duk_push_global_object(c) // [ func global ]
+	} else {
+		pushJsProxyValue(c, this)   // [ func this ]
+	}
+	for _, arg := range args {
+		pushJsProxyValue(c, arg)
+	}
+	// [ func this arg1 ... argN ]
+	if err = takePushError(c); err != nil {
+		C.duk_pop_n(c, C.duk_idx_t(len(args)+2))
+		return
+	}
 	defer C.duk_pop(c)
+	if C.duk_pcall_method(c, C.duk_idx_t(len(args))) != 0 { // [ retval/error ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(c, -1)))
+		return
+	}
 	return fromJsValue(c)
 }
 
@@ -148,7 +351,7 @@ func dump(ctx *C.duk_context, prompt string) {
 	fmt.Printf("--- %s END ---\n", prompt)
 }*/
 
-func setEnv(ctx *C.duk_context, env map[string]interface{}) {
+func setEnv(ctx *C.duk_context, env map[string]interface{}) (err error) {
 	C.duk_push_global_object(ctx) // [ global ]
 	defer C.duk_pop(ctx) // [ ]
 
@@ -156,8 +359,37 @@ func setEnv(ctx *C.duk_context, env map[string]interface{}) {
 		v := env[k]
 		pushString(ctx, k)  // [ global k ]
 		pushJsProxyValue(ctx, v)  // [ global k v ]
+		if err = takePushError(ctx); err != nil {
+			C.duk_pop_n(ctx, 2) // [ global ]
+			return
+		}
 		C.duk_put_prop(ctx, -3) // [ global ] with global[k] = v
 	}
+	return
+}
+
+// resetEnv sets env as globals, and deletes the globals set by prevEnv but absent in env.
+// If an error occurs, env may be partially set.
+func (ctx *JsContext) resetEnv(env map[string]interface{}, prevEnv map[string]interface{}) (err error) {
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	// external buffers in env are detached when returning, like the ones passed to Eval().
+	enterCall(c)
+	defer leaveCall(c)
+	C.duk_push_global_object(c) // [ global ]
+	for k, _ := range prevEnv {
+		if _, ok := env[k]; ok {
+			continue
+		}
+		pushString(c, k)  // [ global k ]
+		C.clearProp(c, -2) // [ global ]
+	}
+	C.duk_pop(c) // [ ]
+	return setEnv(c, env)
 }
 
 func getVar(ctx *C.duk_context, name string) (exsiting bool) {
@@ -169,23 +401,36 @@ func getVar(ctx *C.duk_context, name string) (exsiting bool) {
 }
 
 func (ctx *JsContext) GetGlobal(name string) (res interface{}, err error) {
-	ctx.mu.Lock()
-	defer ctx.mu.Unlock()
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
 
 	c := ctx.c
 	C.duk_push_global_object(c) // [ global ]
 	defer C.duk_pop_n(c, 2) // [ ]
 
-	if !getVar(c, name) { // [ global result ]
+	if !getVar(c, name) && !hasVar(c, -2, name) { // [ global result ]
 		err = fmt.Errorf("global %s not found", name)
 		return
 	}
-	return fromJsValue(c)
+	return fromJsResult(c)
+}
+
+// hasVar checks whether the object at objIdx has the property name, which may be undefined.
+func hasVar(ctx *C.duk_context, objIdx C.duk_idx_t, name string) bool {
+	pushString(ctx, name)
+	if objIdx < 0 {
+		objIdx -= 1
+	}
+	return C.duk_has_prop(ctx, objIdx) != 0 // the name is popped
 }
 
 func (ctx *JsContext) CallFunc(funcName string, args ...interface{}) (res interface{}, err error) {
-	ctx.mu.Lock()
-	defer ctx.mu.Unlock()
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
 
 	c := ctx.c
 
@@ -202,8 +447,43 @@ func (ctx *JsContext) CallFunc(funcName string, args ...interface{}) (res interf
 		return
 	}
 
-	callFunc(c, args...) // [ global retval ]
-	return fromJsValue(c)
+	enterCall(c)
+	defer leaveCall(c)
+	if err = callFunc(c, args...); err != nil { // [ global retval ]
+		return
+	}
+	return fromJsResult(c)
+}
+
+// CallFuncBorrow is CallFunc without copying the strings and []byte in the result,
+// see EvalBorrow.
+func (ctx *JsContext) CallFuncBorrow(funcName string, fn func(res interface{}) error, args ...interface{}) (err error) {
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+
+	C.duk_push_global_object(c) // [ global ]
+	defer C.duk_pop_n(c, 2) // [ ]
+
+	if !getVar(c, funcName) { // [ global funcName-result ]
+		err = fmt.Errorf("function %s not found", funcName)
+		return
+	}
+
+	if C.duk_is_function(c, -1) == 0 {
+		err = fmt.Errorf("var %s is not with type function", funcName)
+		return
+	}
+
+	enterCall(c)
+	defer leaveCall(c)
+	if err = callFunc(c, args...); err != nil { // [ global retval ]
+		return
+	}
+	return borrowResult(c, fn)
 }
 
 // bind a var of golang func with a JS function name, so calling JS function
@@ -220,8 +500,10 @@ func (ctx *JsContext) BindFunc(funcName string, funcVarPtr interface{}) (err err
 		return
 	}
 
-	ctx.mu.Lock()
-	defer ctx.mu.Unlock()
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
 
 	c := ctx.c
 
@@ -250,3 +532,48 @@ func (ctx *JsContext) BindFuncs(funcName2FuncVarPtr map[string]interface{}) (err
 	}
 	return
 }
+
+func (ctx *JsContext) globalNames() (names map[string]struct{}) {
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	names = make(map[string]struct{})
+	C.duk_push_global_object(c) // [ global ]
+	C.duk_enum(c, -1, C.DUK_ENUM_OWN_PROPERTIES_ONLY|C.DUK_ENUM_INCLUDE_NONENUMERABLE) // [ global enum ]
+	for C.duk_next(c, -1, 0) != 0 {
+		// [ global enum key ]
+		names[C.GoString(C.getCString(c, -1))] = struct{}{}
+		C.duk_pop(c) // [ global enum ]
+	}
+	C.duk_pop_n(c, 2) // [ ]
+	return
+}
+
+// delete globals not in names, globals that cannot be deleted are set to undefined.
+func (ctx *JsContext) deleteGlobalsExcept(names map[string]struct{}) {
+	added := []string{}
+	for name, _ := range ctx.globalNames() {
+		if _, ok := names[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	C.duk_push_global_object(c) // [ global ]
+	for _, name := range added {
+		pushString(c, name)  // [ global name ]
+		C.clearProp(c, -2)   // [ global ]
+	}
+	C.duk_pop(c) // [ ]
+}
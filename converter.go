@@ -0,0 +1,190 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	elutils "github.com/rosbit/go-embedding-utils"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// FnToJS converts a Go value of the registered type to a value which can be pushed to JS.
+type FnToJS func(v interface{}) (interface{}, error)
+
+// FnFromJS converts a value got from JS to a value of the registered type.
+type FnFromJS func(v interface{}) (interface{}, error)
+
+type converter struct {
+	toJS   FnToJS
+	fromJS FnFromJS
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// RegisterConverter defines how values of type t cross the bridge. toJS is called
+// when a value of type t is pushed to JS, fromJS is called when a JS value is
+// stored in a Go value of type t by EvalInto() or by setting a property of a Go
+// object. Either of them can be nil.
+func (ctx *JsContext) RegisterConverter(t reflect.Type, toJS FnToJS, fromJS FnFromJS) {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx.c)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if toJS == nil && fromJS == nil {
+		delete(s.converters, t)
+		return
+	}
+	if s.converters == nil {
+		s.converters = make(map[reflect.Type]*converter)
+	}
+	s.converters[t] = &converter{toJS: toJS, fromJS: fromJS}
+}
+
+func (s *ctxState) getConverter(t reflect.Type) *converter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.converters[t]
+}
+
+// pushConverted pushes v converted by the registered converter of its type.
+func pushConverted(ctx *C.duk_context, v interface{}) (pushed bool) {
+	t := reflect.TypeOf(v)
+	conv := getCtxState(uintptr(unsafe.Pointer(ctx))).getConverter(t)
+	if conv == nil || conv.toJS == nil {
+		return
+	}
+	pushed = true
+	jsVal, err := conv.toJS(v)
+	if err != nil {
+		setPushError(ctx, err)
+		C.duk_push_undefined(ctx)
+		return
+	}
+	if jsVal != nil && reflect.TypeOf(jsVal) == t {
+		setPushError(ctx, fmt.Errorf("converter of %v returns a value of the same type", t))
+		C.duk_push_undefined(ctx)
+		return
+	}
+	pushJsProxyValue(ctx, jsVal)
+	return
+}
+
+// pushMarshaled pushes the result of MarshalJSON() as a JS value, or the
+// result of MarshalText() as a string, if Options.UseMarshalers is set.
+func pushMarshaled(ctx *C.duk_context, v interface{}) (pushed bool) {
+	if !getCtxState(uintptr(unsafe.Pointer(ctx))).options.UseMarshalers {
+		return
+	}
+	if vv := reflect.ValueOf(v); vv.Kind() == reflect.Ptr && vv.IsNil() {
+		return
+	}
+
+	switch m := v.(type) {
+	case json.Marshaler:
+		pushed = true
+		b, err := m.MarshalJSON()
+		if err == nil && !json.Valid(b) {
+			err = fmt.Errorf("invalid JSON from MarshalJSON of %T", v)
+		}
+		if err != nil {
+			setPushError(ctx, err)
+			C.duk_push_undefined(ctx)
+			return
+		}
+		pushString(ctx, string(b)) // [ ... json ]
+		C.duk_json_decode(ctx, -1) // [ ... v ]
+	case encoding.TextMarshaler:
+		pushed = true
+		b, err := m.MarshalText()
+		if err != nil {
+			setPushError(ctx, err)
+			C.duk_push_undefined(ctx)
+			return
+		}
+		pushString(ctx, string(b))
+	}
+	return
+}
+
+// decodeConverted stores val in dest by the registered converter of the type of dest,
+// or by UnmarshalJSON()/UnmarshalText() if Options.UseMarshalers is set.
+func (d *decoder) decodeConverted(dest reflect.Value, val interface{}) (decoded bool, err error) {
+	if d.state == nil {
+		return
+	}
+	t := dest.Type()
+	if conv := d.state.getConverter(t); conv != nil && conv.fromJS != nil {
+		decoded = true
+		goVal, e := conv.fromJS(val)
+		if e != nil {
+			err = e
+			return
+		}
+		if goVal == nil {
+			dest.Set(reflect.Zero(t))
+			return
+		}
+		gv := reflect.ValueOf(goVal)
+		if !gv.Type().AssignableTo(t) {
+			err = fmt.Errorf("converter of %v returns a value of %v", t, gv.Type())
+			return
+		}
+		dest.Set(gv)
+		return
+	}
+
+	if !d.state.options.UseMarshalers || t == timeType || !dest.CanAddr() {
+		return
+	}
+	switch p := dest.Addr(); {
+	case p.Type().Implements(jsonUnmarshalerType):
+		b, e := json.Marshal(val)
+		if e != nil {
+			err = e
+			return
+		}
+		decoded = true
+		err = p.Interface().(json.Unmarshaler).UnmarshalJSON(b)
+	case p.Type().Implements(textUnmarshalerType):
+		s, ok := val.(string)
+		if !ok {
+			return
+		}
+		decoded = true
+		err = p.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	return
+}
+
+// setValue is elutils.SetValue() applying the registered converters.
+func setValue(ctx *C.duk_context, dest reflect.Value, val interface{}) (err error) {
+	d := &decoder{state: getCtxState(uintptr(unsafe.Pointer(ctx)))}
+	val = plainNil(dest.Type(), val)
+	if dest.Type() == jsFuncType && val != nil {
+		return decodeJsFunction(dest, val)
+	}
+	v := reflect.New(dest.Type()).Elem()
+	decoded, err := d.decodeConverted(v, val)
+	if err != nil {
+		return
+	}
+	if decoded {
+		dest.Set(v)
+		return
+	}
+	if val != nil {
+		// the same as EvalInto(), durations are pushed to JS as milliseconds.
+		switch dest.Type() {
+		case timeType:
+			return decodeTime(dest, val)
+		case durationType:
+			return decodeDuration(dest, val)
+		}
+	}
+	return elutils.SetValue(dest, val)
+}
@@ -0,0 +1,211 @@
+package djs
+
+// #include <stdint.h>
+// #include <pthread.h>
+// #include "duktape.h"
+// static uintptr_t threadID() {
+// 	return (uintptr_t)pthread_self();
+// }
+import "C"
+import (
+	"unsafe"
+	"reflect"
+	"runtime"
+	"crypto/sha256"
+	"encoding/hex"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+type modFile struct {
+	id   string // resolved module id
+	hash string // hash of content
+}
+
+// ctxState keeps the states of a duktape context which must be accessed
+// in the callbacks called by duktape.
+type ctxState struct {
+	lock *sync.Mutex
+	options Options
+	modFiles map[string]*modFile // module files loaded by require()
+	pushErr error // error occurred in pushJsProxyValue()
+	callDepth int
+	borrowing bool // strings and buffers converted to Go are not copied
+	strict bool // undefined and null are converted to Undefined and Null
+	pinner runtime.Pinner // pins the memory of external buffers
+	converters map[reflect.Type]*converter // registered by RegisterConverter()
+	ctxLock *ctxLock // the lock of JsContext, shared by the contexts of a Heap
+	closed bool // the context is freed, guarded by ctxLock
+	heap uintptr // the main thread of the heap, which keys the store of Go values
+	jsCtx *JsContext // the context while it is locked, guarded by ctxLock
+	entered int // times jsCtx is locked
+	releasedFuncs []uint32 // ids of JS functions whose handles are garbage collected by Go
+}
+
+// ctxLock is the lock of a context, or of all contexts of a Heap. It is reentrant, so a
+// Go function called by JS can call JS functions of the heap. The goroutine holding it is
+// locked to its OS thread, which is the owner of the lock: a Go function called by JS runs
+// on the thread of the caller, and no other goroutine runs on the thread until unlocking.
+type ctxLock struct {
+	mu sync.Mutex
+	owner atomic.Uintptr // the thread holding the lock, 0 if not locked
+	depth int // times locked by the owner
+}
+
+func (l *ctxLock) Lock() {
+	runtime.LockOSThread()
+	l.lock(uintptr(C.threadID()))
+}
+
+func (l *ctxLock) lock(tid uintptr) {
+	if l.owner.Load() == tid {
+		l.depth += 1
+		return
+	}
+	l.mu.Lock()
+	l.owner.Store(tid)
+	l.depth = 1
+}
+
+func (l *ctxLock) Unlock() {
+	if l.depth -= 1; l.depth == 0 {
+		l.owner.Store(0)
+		l.mu.Unlock()
+	}
+	runtime.UnlockOSThread()
+}
+
+// lockContext is Lock() giving up if goCtx is done before l is locked.
+func (l *ctxLock) lockContext(goCtx context.Context) (err error) {
+	runtime.LockOSThread()
+	tid := uintptr(C.threadID())
+	if l.owner.Load() == tid || goCtx.Done() == nil {
+		l.lock(tid)
+		return
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		l.mu.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		l.owner.Store(tid)
+		l.depth = 1
+	case <-goCtx.Done():
+		go func() {
+			<-locked
+			l.mu.Unlock()
+		}()
+		runtime.UnlockOSThread()
+		err = goCtx.Err()
+	}
+	return
+}
+
+var (
+	ctxStates = make(map[uintptr]*ctxState)
+	ctxStatesLock = &sync.Mutex{}
+)
+
+func getCtxState(ctx uintptr) *ctxState {
+	ctxStatesLock.Lock()
+	defer ctxStatesLock.Unlock()
+
+	if state, ok := ctxStates[ctx]; ok {
+		return state
+	}
+	state := &ctxState{
+		lock: &sync.Mutex{},
+		modFiles: make(map[string]*modFile),
+	}
+	ctxStates[ctx] = state
+	return state
+}
+
+func delCtxState(ctx uintptr) {
+	ctxStatesLock.Lock()
+	defer ctxStatesLock.Unlock()
+	delete(ctxStates, ctx)
+}
+
+// delCtxStateIf deletes the state of ctx if it is still state, the address of a
+// destroyed context may be reused by a new one.
+func delCtxStateIf(ctx uintptr, state *ctxState) {
+	ctxStatesLock.Lock()
+	defer ctxStatesLock.Unlock()
+	if ctxStates[ctx] == state {
+		delete(ctxStates, ctx)
+	}
+}
+
+func (s *ctxState) addModFile(path string, id string, content []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.modFiles[path] = &modFile{id: id, hash: hashContent(content)}
+}
+
+func (s *ctxState) getModFiles() (modFiles map[string]modFile) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	modFiles = make(map[string]modFile, len(s.modFiles))
+	for path, mod := range s.modFiles {
+		modFiles[path] = *mod
+	}
+	return
+}
+
+// addReleasedFunc records the id of a JS function to be removed from the stash the
+// next time the context is locked, it is called by finalizers which must not block.
+func (s *ctxState) addReleasedFunc(id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.releasedFuncs = append(s.releasedFuncs, id)
+}
+
+func (s *ctxState) takeReleasedFuncs() (ids []uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ids, s.releasedFuncs = s.releasedFuncs, nil
+	return
+}
+
+func setPushError(ctx *C.duk_context, err error) {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pushErr == nil {
+		s.pushErr = err
+	}
+}
+
+// takePushError returns and clears the first error occurred in pushJsProxyValue().
+func takePushError(ctx *C.duk_context) (err error) {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err, s.pushErr = s.pushErr, nil
+	return
+}
+
+func isBorrowing(ctx *C.duk_context) bool {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.borrowing
+}
+
+func setBorrowing(ctx *C.duk_context, borrowing bool) {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.borrowing = borrowing
+}
+
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
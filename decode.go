@@ -0,0 +1,233 @@
+package djs
+
+import (
+	elutils "github.com/rosbit/go-embedding-utils"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+// EvalInto evaluates script and stores the result in the value pointed by dest.
+// Besides the conversion done by BindFunc(), numbers and numeric strings (for
+// integers out of the safe range of JS numbers) are converted to Go integers
+// without losing precision, Date objects are converted to time.Time, numbers
+// of milliseconds are converted to time.Duration, and values of the types with
+// registered converters are converted by them.
+func (ctx *JsContext) EvalInto(script string, env map[string]interface{}, dest interface{}) (err error) {
+	res, e := ctx.Eval(script, env)
+	if e != nil {
+		err = e
+		return
+	}
+	d := &decoder{state: getCtxState(uintptr(unsafe.Pointer(ctx.c)))}
+	return d.decodeInto(res, dest)
+}
+
+// decoder applies the converters registered in the context when decoding.
+type decoder struct {
+	state *ctxState
+}
+
+func (d *decoder) decodeInto(val interface{}, dest interface{}) (err error) {
+	if dest == nil {
+		err = fmt.Errorf("dest must be a non-nil pointer")
+		return
+	}
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		err = fmt.Errorf("dest must be a non-nil pointer")
+		return
+	}
+	return d.decodeValue(v.Elem(), val)
+}
+
+// decodeValue is elutils.SetValue() with more conversions, it is applied recursively
+// to the elements of maps, structs and slices.
+func (d *decoder) decodeValue(dest reflect.Value, val interface{}) (err error) {
+	val = plainNil(dest.Type(), val)
+	if decoded, e := d.decodeConverted(dest, val); decoded || e != nil {
+		err = e
+		return
+	}
+	if val == nil {
+		dest.Set(reflect.Zero(dest.Type()))
+		return
+	}
+
+	switch dest.Type() {
+	case timeType:
+		return decodeTime(dest, val)
+	case durationType:
+		return decodeDuration(dest, val)
+	case jsFuncType:
+		return decodeJsFunction(dest, val)
+	}
+
+	switch dest.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, e := toInt64(val)
+		if e != nil {
+			err = e
+			return
+		}
+		if dest.OverflowInt(i) {
+			err = fmt.Errorf("%d overflows %s", i, dest.Type())
+			return
+		}
+		dest.SetInt(i)
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, e := toUint64(val)
+		if e != nil {
+			err = e
+			return
+		}
+		if dest.OverflowUint(u) {
+			err = fmt.Errorf("%d overflows %s", u, dest.Type())
+			return
+		}
+		dest.SetUint(u)
+		return
+	case reflect.Ptr:
+		ev := reflect.New(dest.Type().Elem())
+		if err = d.decodeValue(ev.Elem(), val); err != nil {
+			return
+		}
+		dest.Set(ev)
+		return
+	case reflect.Struct:
+		if m, ok := val.(map[string]interface{}); ok {
+			return d.decodeStruct(dest, m)
+		}
+	case reflect.Map:
+		if m, ok := val.(map[string]interface{}); ok {
+			return d.decodeMap(dest, m)
+		}
+	case reflect.Slice:
+		if a, ok := val.([]interface{}); ok {
+			s := reflect.MakeSlice(dest.Type(), len(a), len(a))
+			for i, e := range a {
+				if err = d.decodeValue(s.Index(i), e); err != nil {
+					return
+				}
+			}
+			dest.Set(s)
+			return
+		}
+	case reflect.Array:
+		if a, ok := val.([]interface{}); ok {
+			for i:=0; i<len(a) && i<dest.Len(); i++ {
+				if err = d.decodeValue(dest.Index(i), a[i]); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+
+	return elutils.SetValue(dest, val)
+}
+
+func (d *decoder) decodeStruct(dest reflect.Value, m map[string]interface{}) (err error) {
+	t := dest.Type()
+	for i:=0; i<t.NumField(); i++ {
+		ft := t.Field(i)
+		if !ft.IsExported() {
+			continue
+		}
+		name, ok := fieldName(ft)
+		if !ok {
+			continue
+		}
+		v, ok := m[name]
+		if !ok {
+			continue
+		}
+		if err = d.decodeValue(dest.Field(i), v); err != nil {
+			err = fmt.Errorf("field %s: %v", ft.Name, err)
+			return
+		}
+	}
+	return
+}
+
+func (d *decoder) decodeMap(dest reflect.Value, m map[string]interface{}) (err error) {
+	t := dest.Type()
+	res := reflect.MakeMapWithSize(t, len(m))
+	for k, v := range m {
+		kv, e := toMapKey(t.Key(), k)
+		if e != nil {
+			err = e
+			return
+		}
+		ev := reflect.New(t.Elem()).Elem()
+		if err = d.decodeValue(ev, v); err != nil {
+			return
+		}
+		res.SetMapIndex(kv, ev)
+	}
+	dest.Set(res)
+	return
+}
+
+func toInt64(val interface{}) (i int64, err error) {
+	switch v := val.(type) {
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			err = fmt.Errorf("%v cannot be converted to integer", v)
+			return
+		}
+		i = int64(v)
+	case int64:
+		i = v
+	case uint64:
+		if v > math.MaxInt64 {
+			err = fmt.Errorf("%d overflows int64", v)
+			return
+		}
+		i = int64(v)
+	case string:
+		i, err = strconv.ParseInt(v, 10, 64)
+	default:
+		rv := reflect.ValueOf(val)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = rv.Int()
+		default:
+			err = fmt.Errorf("cannot convert %T to integer", val)
+		}
+	}
+	return
+}
+
+func toUint64(val interface{}) (u uint64, err error) {
+	switch v := val.(type) {
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			err = fmt.Errorf("%v cannot be converted to unsigned integer", v)
+			return
+		}
+		u = uint64(v)
+	case uint64:
+		u = v
+	case int64:
+		if v < 0 {
+			err = fmt.Errorf("%d cannot be converted to unsigned integer", v)
+			return
+		}
+		u = uint64(v)
+	case string:
+		u, err = strconv.ParseUint(v, 10, 64)
+	default:
+		rv := reflect.ValueOf(val)
+		switch rv.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u = rv.Uint()
+		default:
+			err = fmt.Errorf("cannot convert %T to unsigned integer", val)
+		}
+	}
+	return
+}
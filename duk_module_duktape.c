@@ -231,7 +231,7 @@ static duk_ret_t duk__require(duk_context *ctx) {
 	 *  references can be supported to some extent.
 	 */
 
-	duk_push_global_stash(ctx);
+	duk_push_global_object(ctx);  /* stashed in the global object, a heap may have many */
 	duk_get_prop_string(ctx, -1, "\xff" "module:Duktape");
 	duk_remove(ctx, -2);  /* Lookup stashed, original 'Duktape' object. */
 	duk_get_prop_string(ctx, DUK__IDX_DUKTAPE, "modLoaded");  /* Duktape.modLoaded */
@@ -437,8 +437,10 @@ static duk_ret_t duk__require(duk_context *ctx) {
 }
 
 void duk_module_duktape_init(duk_context *ctx) {
-	/* Stash 'Duktape' in case it's modified. */
-	duk_push_global_stash(ctx);
+	/* Stash 'Duktape' in case it's modified, as a hidden property of the global object
+	 * because threads with new global environments of a heap share the global stash.
+	 */
+	duk_push_global_object(ctx);
 	duk_get_global_string(ctx, "Duktape");
 	duk_put_prop_string(ctx, -2, "\xff" "module:Duktape");
 	duk_pop(ctx);
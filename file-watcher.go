@@ -0,0 +1,91 @@
+package djs
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+type fnFileChanged func(path string)
+
+type fileWatcher interface {
+	add(path string) error
+	remove(path string)
+	close()
+}
+
+// pollWatcher checks the modification time of the watched files periodically.
+type pollWatcher struct {
+	lock *sync.Mutex
+	files map[string]time.Time // zero time if the file does not exist
+	onChange fnFileChanged
+	done chan struct{}
+}
+
+func newPollWatcher(interval time.Duration, onChange fnFileChanged) *pollWatcher {
+	w := &pollWatcher{
+		lock: &sync.Mutex{},
+		files: make(map[string]time.Time),
+		onChange: onChange,
+		done: make(chan struct{}),
+	}
+	go w.run(interval)
+	return w
+}
+
+func (w *pollWatcher) add(path string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.files[path]; ok {
+		return nil
+	}
+	w.files[path] = modTime(path)
+	return nil
+}
+
+func (w *pollWatcher) remove(path string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.files, path)
+}
+
+// modTime returns the modification time of path, or zero time if it does not exist.
+func modTime(path string) (mt time.Time) {
+	if fi, err := os.Stat(path); err == nil {
+		mt = fi.ModTime()
+	}
+	return
+}
+
+func (w *pollWatcher) close() {
+	close(w.done)
+}
+
+func (w *pollWatcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		changed := []string{}
+		w.lock.Lock()
+		for path, mt := range w.files {
+			// a deleted file is still polled, so restoring it is noticed.
+			if newMt := modTime(path); !newMt.Equal(mt) {
+				w.files[path] = newMt
+				changed = append(changed, path)
+			}
+		}
+		w.lock.Unlock()
+
+		for _, path := range changed {
+			w.onChange(path)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package djs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// inotifyWatcher watches the directories of files, so files replaced by
+// renaming (as many editors do) are still watched.
+type inotifyWatcher struct {
+	lock *sync.Mutex
+	f *os.File
+	fd int
+	dirs map[int]string // wd -> dir
+	wds map[string]int  // dir -> wd
+	files map[string]struct{}
+	onChange fnFileChanged
+}
+
+func newFileWatcher(pollInterval time.Duration, onChange fnFileChanged) fileWatcher {
+	if w, err := newInotifyWatcher(onChange); err == nil {
+		return w
+	}
+	return newPollWatcher(pollInterval, onChange)
+}
+
+func newInotifyWatcher(onChange fnFileChanged) (w *inotifyWatcher, err error) {
+	fd, e := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if e != nil {
+		err = e
+		return
+	}
+	w = &inotifyWatcher{
+		lock: &sync.Mutex{},
+		f: os.NewFile(uintptr(fd), "inotify"), // non-blocking fd is added to the runtime poller
+		fd: fd,
+		dirs: make(map[int]string),
+		wds: make(map[string]int),
+		files: make(map[string]struct{}),
+		onChange: onChange,
+	}
+	go w.run()
+	return
+}
+
+func (w *inotifyWatcher) add(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(absPath)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.wds[dir]; !ok {
+		wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.wds[dir] = wd
+		w.dirs[wd] = dir
+	}
+	w.files[absPath] = struct{}{}
+	return nil
+}
+
+// remove stops watching path, and its directory if no other file in it is watched.
+func (w *inotifyWatcher) remove(path string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.files, path)
+	dir := filepath.Dir(path)
+	for file, _ := range w.files {
+		if filepath.Dir(file) == dir {
+			return
+		}
+	}
+	if wd, ok := w.wds[dir]; ok {
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.wds, dir)
+		delete(w.dirs, wd)
+	}
+}
+
+func (w *inotifyWatcher) close() {
+	w.f.Close() // makes Read() in run() return
+}
+
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+
+		changed := map[string]struct{}{}
+		w.lock.Lock()
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			dir, ok := w.dirs[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			name := string(nameBytes)
+			for i, c := range nameBytes {
+				if c == 0 {
+					name = string(nameBytes[:i])
+					break
+				}
+			}
+			path := filepath.Join(dir, name)
+			if _, ok := w.files[path]; ok {
+				changed[path] = struct{}{}
+			}
+		}
+		w.lock.Unlock()
+
+		for path, _ := range changed {
+			w.onChange(path)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package djs
+
+import (
+	"time"
+)
+
+func newFileWatcher(pollInterval time.Duration, onChange fnFileChanged) fileWatcher {
+	return newPollWatcher(pollInterval, onChange)
+}
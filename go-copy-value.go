@@ -0,0 +1,146 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"reflect"
+	"strings"
+)
+
+// pushJsCopyValue pushes maps, structs, slices and arrays in v as native JS objects
+// and arrays instead of proxies, other values are pushed as pushJsProxyValue() does.
+func pushJsCopyValue(ctx *C.duk_context, v interface{}, freeze bool) {
+	pushCopyValue(ctx, reflect.ValueOf(v), freeze, map[visitedValue]bool{})
+}
+
+type visitedValue struct {
+	kind reflect.Kind
+	ptr  uintptr
+}
+
+func pushCopyValue(ctx *C.duk_context, vv reflect.Value, freeze bool, visiting map[visitedValue]bool) {
+	if !vv.IsValid() {
+		C.duk_push_null(ctx)
+		return
+	}
+
+	if t := vv.Type(); (t == timeType || t == durationType || t == specialType) && vv.CanInterface() {
+		pushJsProxyValue(ctx, vv.Interface())
+		return
+	}
+	if vv.CanInterface() && (pushConverted(ctx, vv.Interface()) || pushMarshaled(ctx, vv.Interface())) {
+		return
+	}
+
+	switch vv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if vv.IsNil() {
+			C.duk_push_null(ctx)
+			return
+		}
+		if vv.Kind() == reflect.Ptr {
+			visited := visitedValue{vv.Kind(), vv.Pointer()}
+			if visiting[visited] {
+				C.duk_push_null(ctx) // cycle
+				return
+			}
+			visiting[visited] = true
+			defer delete(visiting, visited)
+		}
+		pushCopyValue(ctx, vv.Elem(), freeze, visiting)
+		return
+	case reflect.Slice:
+		if vv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if vv.IsNil() {
+			C.duk_push_null(ctx)
+			return
+		}
+		visited := visitedValue{vv.Kind(), vv.Pointer()}
+		if visiting[visited] {
+			C.duk_push_null(ctx)
+			return
+		}
+		visiting[visited] = true
+		defer delete(visiting, visited)
+		fallthrough
+	case reflect.Array:
+		C.duk_push_array(ctx) // [ arr ]
+		for i:=0; i<vv.Len(); i++ {
+			pushCopyValue(ctx, vv.Index(i), freeze, visiting) // [ arr v ]
+			C.duk_put_prop_index(ctx, -2, C.duk_uarridx_t(i))   // [ arr ] with arr[i] = v
+		}
+		if freeze {
+			C.duk_freeze(ctx, -1)
+		}
+		return
+	case reflect.Map:
+		if vv.IsNil() {
+			C.duk_push_null(ctx)
+			return
+		}
+		visited := visitedValue{vv.Kind(), vv.Pointer()}
+		if visiting[visited] {
+			C.duk_push_null(ctx)
+			return
+		}
+		visiting[visited] = true
+		defer delete(visiting, visited)
+
+		C.duk_push_object(ctx) // [ obj ]
+		it := vv.MapRange()
+		for it.Next() {
+			pushString(ctx, mapKeyString(it.Key()))          // [ obj k ]
+			pushCopyValue(ctx, it.Value(), freeze, visiting) // [ obj k v ]
+			C.duk_put_prop(ctx, -3)                          // [ obj ] with obj[k] = v
+		}
+		if freeze {
+			C.duk_freeze(ctx, -1)
+		}
+		return
+	case reflect.Struct:
+		C.duk_push_object(ctx) // [ obj ]
+		t := vv.Type()
+		for i:=0; i<t.NumField(); i++ {
+			ft := t.Field(i)
+			if !ft.IsExported() {
+				continue
+			}
+			name, ok := fieldName(ft)
+			if !ok {
+				continue
+			}
+			pushString(ctx, name)                                 // [ obj name ]
+			pushCopyValue(ctx, vv.Field(i), freeze, visiting) // [ obj name v ]
+			C.duk_put_prop(ctx, -3)                               // [ obj ] with obj[name] = v
+		}
+		if freeze {
+			C.duk_freeze(ctx, -1)
+		}
+		return
+	}
+
+	if !vv.CanInterface() {
+		C.duk_push_undefined(ctx)
+		return
+	}
+	pushJsProxyValue(ctx, vv.Interface())
+}
+
+// fieldName returns the name of a struct field in JS, the same as elutils.SetValue()
+// converting a map to a struct: the json tag, or the field name with the first letter lowered.
+func fieldName(ft reflect.StructField) (name string, ok bool) {
+	if tv := ft.Tag.Get("json"); len(tv) > 0 {
+		if tv == "-" {
+			return
+		}
+		if pos := strings.Index(tv, ","); pos >= 0 {
+			tv = tv[:pos]
+		}
+		if len(tv) > 0 {
+			return tv, true
+		}
+	}
+	return lowerFirst(ft.Name), true
+}
@@ -0,0 +1,193 @@
+package djs
+
+// #include "duktape.h"
+// extern duk_ret_t go_func_apply(duk_context *ctx);
+// duk_ret_t goFuncApply(duk_context *ctx) {
+// 	duk_ret_t rc = go_func_apply(ctx);
+// 	if (rc == -100) {
+// 		// [ ... message ], thrown here instead of in Go
+// 		duk_push_error_object(ctx, DUK_ERR_ERROR, "%s", duk_safe_to_string(ctx, -1));
+// 		return duk_throw(ctx);
+// 	}
+// 	return rc;
+// }
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// returned by go_func_apply() to throw an Error with the message on the top of the stack.
+const retThrow C.duk_ret_t = -100
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	methodCacheName = "\xFFmethods\x00"
+)
+
+// structCopy is an addressable copy of a struct value pushed to JS, so that
+// fields can be set and methods with pointer receivers can be called.
+type structCopy struct {
+	ptr reflect.Value
+}
+
+func newStructCopy(vv reflect.Value) *structCopy {
+	ptr := reflect.New(vv.Type())
+	ptr.Elem().Set(vv)
+	return &structCopy{ptr: ptr}
+}
+
+type namedResultsFunc struct {
+	fn    interface{}
+	names []string
+}
+
+// NamedResults wraps a Go function with multiple results, which are returned to JS
+// as an object with the names as keys instead of an array.
+func NamedResults(fn interface{}, names ...string) interface{} {
+	return &namedResultsFunc{fn: fn, names: names}
+}
+
+// getGoValue returns the Go value of the proxy at idx, as it was pushed to JS.
+func getGoValue(ctx *C.duk_context, idx C.duk_idx_t) (v interface{}, isProxy bool) {
+	if v, isProxy = getTargetValue(ctx, idx); !isProxy {
+		return
+	}
+	switch w := v.(type) {
+	case *readOnlyValue:
+		v = w.v
+	case *structCopy:
+		v = w.ptr.Elem().Interface()
+	case *namedResultsFunc:
+		v = w.fn
+	}
+	return
+}
+
+// callGoFunc calls fnVal with the args in the array at index 2.
+func callGoFunc(ctx *C.duk_context, fnVal reflect.Value) (results []reflect.Value, err error) {
+	fnType := fnVal.Type()
+	argc := int(C.duk_get_length(ctx, 2))
+	variadic := fnType.IsVariadic()
+	lastNumIn := fnType.NumIn() - 1
+	if variadic {
+		if argc < lastNumIn {
+			err = fmt.Errorf("at least %d args expected, %d given", lastNumIn, argc)
+			return
+		}
+	} else if argc != fnType.NumIn() {
+		err = fmt.Errorf("%d args expected, %d given", fnType.NumIn(), argc)
+		return
+	}
+
+	args := make([]reflect.Value, argc)
+	for i:=0; i<argc; i++ {
+		var argType reflect.Type
+		if i < lastNumIn || !variadic {
+			argType = fnType.In(i)
+		} else {
+			argType = fnType.In(lastNumIn).Elem()
+		}
+
+		C.duk_get_prop_index(ctx, 2, C.duk_uarridx_t(i)) // [ ... i-th arg ]
+		goVal, e := fromJsValue(ctx)
+		C.duk_pop(ctx) // [ ... ]
+		if e != nil {
+			err = fmt.Errorf("arg %d: %v", i+1, e)
+			return
+		}
+		args[i] = reflect.New(argType).Elem()
+		if e = setValue(ctx, args[i], goVal); e != nil {
+			err = fmt.Errorf("arg %d: %v", i+1, e)
+			return
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	results = fnVal.Call(args)
+	return
+}
+
+// pushResults pushes the results of a Go function, a non-nil trailing error is thrown,
+// multiple results are pushed as an array, or an object if names are given.
+func pushResults(ctx *C.duk_context, results []reflect.Value, names []string) C.duk_ret_t {
+	n := len(results)
+	if n > 0 && results[n-1].Type().Implements(errorType) {
+		if e := results[n-1]; !isNilValue(e) {
+			return throwError(ctx, e.Interface().(error))
+		}
+		n -= 1
+	}
+
+	switch {
+	case n == 0:
+		return 0 // undefined
+	case n == 1 && len(names) == 0:
+		pushJsProxyValue(ctx, results[0].Interface())
+	case len(names) > 0:
+		C.duk_push_object(ctx) // [ obj ]
+		for i:=0; i<n; i++ {
+			name := strconv.Itoa(i)
+			if i < len(names) {
+				name = names[i]
+			}
+			pushString(ctx, name)                         // [ obj name ]
+			pushJsProxyValue(ctx, results[i].Interface()) // [ obj name v ]
+			C.duk_put_prop(ctx, -3)                       // [ obj ] with obj[name] = v
+		}
+	default:
+		C.duk_push_array(ctx) // [ arr ]
+		for i:=0; i<n; i++ {
+			pushJsProxyValue(ctx, results[i].Interface())   // [ arr v ]
+			C.duk_put_prop_index(ctx, -2, C.duk_uarridx_t(i)) // [ arr ] with arr[i] = v
+		}
+	}
+	if err := takePushError(ctx); err != nil {
+		C.duk_pop(ctx)
+		return throwError(ctx, err)
+	}
+	return 1
+}
+
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+func throwError(ctx *C.duk_context, err error) C.duk_ret_t {
+	pushString(ctx, err.Error())
+	return retThrow
+}
+
+// pushMethod pushes the proxy of method, which is cached in the proxy target at index 0
+// so that accessing the same method of an object gets the same function.
+func pushMethod(ctx *C.duk_context, key string, method reflect.Value) {
+	var name *C.char
+	getStrPtr(&methodCacheName, &name)
+	if C.duk_get_prop_string(ctx, 0, name) == 0 { // [ ... undefined ]
+		C.duk_pop(ctx)                       // [ ... ]
+		C.duk_push_bare_object(ctx)          // [ ... cache ]
+		C.duk_dup(ctx, -1)                   // [ ... cache cache ]
+		C.duk_put_prop_string(ctx, 0, name) // [ ... cache ] with target[methodCacheName] = cache
+	}
+	pushString(ctx, key) // [ ... cache key ]
+	if C.duk_get_prop(ctx, -2) != 0 { // [ ... cache method ]
+		C.duk_remove(ctx, -2) // [ ... method ]
+		return
+	}
+	C.duk_pop(ctx) // [ ... cache ]
+	pushGoFunc(ctx, method.Interface()) // [ ... cache method ]
+	pushString(ctx, key)                // [ ... cache method key ]
+	C.duk_dup(ctx, -2)                  // [ ... cache method key method ]
+	C.duk_put_prop(ctx, -4)             // [ ... cache method ] with cache[key] = method
+	C.duk_remove(ctx, -2)               // [ ... method ]
+}
@@ -4,7 +4,9 @@ package djs
 // extern duk_ret_t go_obj_get(duk_context *ctx);
 // extern duk_ret_t go_obj_set(duk_context *ctx);
 // extern duk_ret_t go_obj_has(duk_context *ctx);
-// extern duk_ret_t go_func_apply(duk_context *ctx);
+// extern duk_ret_t go_obj_delete(duk_context *ctx);
+// extern duk_ret_t go_obj_own_keys(duk_context *ctx);
+// extern duk_ret_t goFuncApply(duk_context *ctx);
 // extern duk_ret_t goDummyFunc(duk_context *ctx);
 // extern duk_ret_t freeTarget(duk_context *ctx);
 import "C"
@@ -13,8 +15,9 @@ import (
 	"reflect"
 	"unsafe"
 	"math"
-	"fmt"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func pushJsProxyValue(ctx *C.duk_context, v interface{}) {
@@ -22,9 +25,76 @@ func pushJsProxyValue(ctx *C.duk_context, v interface{}) {
 		C.duk_push_null(ctx)
 		return
 	}
+	if pushConverted(ctx, v) {
+		return
+	}
+	switch w := v.(type) {
+	case *readOnlyValue:
+		pushReadOnlyValue(ctx, w)
+		return
+	case *frozenValue:
+		pushJsCopyValue(ctx, w.v, true)
+		return
+	case *copiedValue:
+		pushJsCopyValue(ctx, w.v, false)
+		return
+	case time.Time:
+		pushTime(ctx, w)
+		return
+	case *time.Time:
+		if w == nil {
+			C.duk_push_null(ctx)
+		} else {
+			pushTime(ctx, *w)
+		}
+		return
+	case time.Duration:
+		pushDuration(ctx, w)
+		return
+	case *externalBytes:
+		pushExternalBytes(ctx, w.b)
+		return
+	case *structCopy:
+		pushGoObj(ctx, w)
+		return
+	case *JsFunction:
+		if w == nil {
+			C.duk_push_null(ctx)
+		} else {
+			pushJsFunction(ctx, w)
+		}
+		return
+	case *namedResultsFunc:
+		pushGoFunc(ctx, w)
+		return
+	case specialValue:
+		pushSpecialValue(ctx, w)
+		return
+	case RegExp:
+		pushRegExp(ctx, &w)
+		return
+	case *RegExp:
+		if w == nil {
+			C.duk_push_null(ctx)
+		} else {
+			pushRegExp(ctx, w)
+		}
+		return
+	}
+
+	if pushMarshaled(ctx, v) {
+		return
+	}
 
 	vv := reflect.ValueOf(v)
 	switch vv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Ptr:
+		if isCopyValuesContext(ctx) && !(vv.Kind() == reflect.Slice && vv.Type().Elem().Kind() == reflect.Uint8) {
+			pushJsCopyValue(ctx, v, false)
+			return
+		}
+	}
+	switch vv.Kind() {
 	case reflect.Bool:
 		if v.(bool) {
 			C.duk_push_true(ctx)
@@ -33,10 +103,10 @@ func pushJsProxyValue(ctx *C.duk_context, v interface{}) {
 		}
 		return
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		C.duk_push_number(ctx, C.duk_double_t(vv.Int()))
+		pushInt64(ctx, vv.Int())
 		return
-	case reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64:
-		C.duk_push_number(ctx, C.duk_double_t(vv.Uint()))
+	case reflect.Uint,reflect.Uint8,reflect.Uint16,reflect.Uint32,reflect.Uint64,reflect.Uintptr:
+		pushUint64(ctx, vv.Uint())
 		return
 	case reflect.Float32, reflect.Float64:
 		fv := vv.Float()
@@ -52,17 +122,28 @@ func pushJsProxyValue(ctx *C.duk_context, v interface{}) {
 	case reflect.Slice:
 		t := vv.Type()
 		if t.Elem().Kind() == reflect.Uint8 {
-			pushString(ctx, string(v.([]byte)))
+			if isBytesAsStringContext(ctx) {
+				pushString(ctx, string(vv.Bytes()))
+			} else {
+				pushBytes(ctx, vv.Bytes())
+			}
 			return
 		}
 		fallthrough
 	case reflect.Array:
 		pushGoArray(ctx, v)
 		return
-	case reflect.Map, reflect.Struct, reflect.Interface:
+	case reflect.Map, reflect.Interface:
 		pushGoObj(ctx, v)
 		return
+	case reflect.Struct:
+		pushGoObj(ctx, newStructCopy(vv))
+		return
 	case reflect.Ptr:
+		if vv.IsNil() {
+			C.duk_push_null(ctx)
+			return
+		}
 		if vv.Elem().Kind() == reflect.Struct {
 			pushGoObj(ctx, v)
 			return
@@ -78,7 +159,7 @@ func pushJsProxyValue(ctx *C.duk_context, v interface{}) {
 	}
 }
 
-func getTargetIdx(ctx *C.duk_context, targetIdx ...C.duk_idx_t) (idx uint32, isProxy bool) {
+func getTargetIdx(ctx *C.duk_context, targetIdx ...C.duk_idx_t) (idx ptrHandle, isProxy bool) {
 	// [ 0 ] target if no targetIdx
 	// ...
 	var tIdx C.duk_idx_t
@@ -90,7 +171,7 @@ func getTargetIdx(ctx *C.duk_context, targetIdx ...C.duk_idx_t) (idx uint32, isP
 	getStrPtr(&idxName, &name)
 	isProxy = C.duk_get_prop_string(ctx, tIdx, name) != 0 // [ ... idx/undefined ]
 	if isProxy {
-		idx = uint32(C.duk_get_uint(ctx, -1))
+		idx = ptrHandle(C.duk_get_number(ctx, -1))
 	}
 	C.duk_pop(ctx) // [ ... ]
 	return
@@ -99,24 +180,19 @@ func getTargetIdx(ctx *C.duk_context, targetIdx ...C.duk_idx_t) (idx uint32, isP
 func getTargetValue(ctx *C.duk_context, targetIdx ...C.duk_idx_t) (v interface{}, isProxy bool) {
 	// [ 0 ] target if no targetIdx
 	// ....
-	var idx uint32
+	var idx ptrHandle
 	if idx, isProxy = getTargetIdx(ctx, targetIdx...); !isProxy {
 		return
 	}
 
-	ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
-	vPtr, o := ptr.lookup(idx)
-	if !o {
-		isProxy = false
-		return
-	}
-	if vv, o := vPtr.(*interface{}); o {
-		v = *vv
+	ptr := ptrStoreOf(ctx)
+	if v, isProxy = ptr.lookup(idx); !isProxy {
+		v = nil
 	}
 	return
 }
 
-func go_arr_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
+func go_arr_get(ctx *C.duk_context, vv reflect.Value, readOnly bool) C.duk_ret_t {
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -146,7 +222,7 @@ func go_arr_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
-	pushJsProxyValue(ctx, val.Interface())
+	pushJsProxyValue(ctx, readOnlyIf(val.Interface(), readOnly))
 	return 1
 }
 
@@ -177,10 +253,7 @@ func go_arr_set(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 		return 1
 	}
 	dest := vv.Index(key)
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
-	}
-	if err = elutils.SetValue(dest, goVal); err != nil {
+	if err = setValue(ctx, dest, goVal); err != nil {
 		C.duk_push_false(ctx)
 	} else {
 		C.duk_push_true(ctx)
@@ -216,23 +289,23 @@ func go_arr_has(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	return 1
 }
 
-func go_map_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
+func go_map_get(ctx *C.duk_context, vv reflect.Value, readOnly bool) C.duk_ret_t {
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
 	 * [2]: receiver (proxy)
 	 */
-	if C.duk_is_string(ctx, 1) == 0 {
+	key, ok := getMapKey(ctx, vv, 1)
+	if !ok {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
-	key := C.GoString(C.duk_get_string(ctx, 1))
-	val := vv.MapIndex(reflect.ValueOf(key))
+	val := vv.MapIndex(key)
 	if !val.IsValid() || !val.CanInterface() {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
-	pushJsProxyValue(ctx, val.Interface())
+	pushJsProxyValue(ctx, readOnlyIf(val.Interface(), readOnly))
 	return 1
 }
 
@@ -243,11 +316,11 @@ func go_map_set(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	 * [2]: val
 	 * [3]: receiver (proxy)
 	 */
-	if C.duk_is_string(ctx, 1) == 0 {
+	key, ok := getMapKey(ctx, vv, 1)
+	if !ok {
 		C.duk_push_false(ctx)
 		return 1
 	}
-	key := C.GoString(C.duk_get_string(ctx, 1))
 
 	C.duk_dup(ctx, 2) // [ ... val ]
 	goVal, err := fromJsValue(ctx)
@@ -260,11 +333,8 @@ func go_map_set(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	mapT := vv.Type()
 	elType := mapT.Elem()
 	dest := elutils.MakeValue(elType)
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
-	}
-	if err = elutils.SetValue(dest, goVal); err == nil {
-		vv.SetMapIndex(reflect.ValueOf(key), dest)
+	if err = setValue(ctx, dest, goVal); err == nil {
+		vv.SetMapIndex(key, dest)
 		C.duk_push_true(ctx)
 	} else {
 		C.duk_push_false(ctx)
@@ -277,12 +347,12 @@ func go_map_has(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	 * [0]: target
 	 * [1]: key
 	 */
-	if C.duk_is_string(ctx, 1) == 0 {
+	key, ok := getMapKey(ctx, vv, 1)
+	if !ok {
 		C.duk_push_false(ctx)
 		return 1
 	}
-	key := C.GoString(C.duk_get_string(ctx, 1))
-	val := vv.MapIndex(reflect.ValueOf(key))
+	val := vv.MapIndex(key)
 	if !val.IsValid() {
 		C.duk_push_false(ctx)
 	} else {
@@ -291,7 +361,22 @@ func go_map_has(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	return 1
 }
 
-func go_struct_get(ctx *C.duk_context, structVar reflect.Value) C.duk_ret_t {
+func go_map_delete(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
+	/* 'this' binding: handler
+	 * [0]: target
+	 * [1]: key
+	 */
+	key, ok := getMapKey(ctx, vv, 1)
+	if !ok {
+		C.duk_push_false(ctx)
+		return 1
+	}
+	vv.SetMapIndex(key, reflect.Value{})
+	C.duk_push_true(ctx)
+	return 1
+}
+
+func go_struct_get(ctx *C.duk_context, structVar reflect.Value, readOnly bool) C.duk_ret_t {
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -319,30 +404,20 @@ func go_struct_get(ctx *C.duk_context, structVar reflect.Value) C.duk_ret_t {
 	name := upperFirst(key)
 	fv := structE.FieldByName(name)
 	if !fv.IsValid() {
-		fv = structE.MethodByName(name)
-		if !fv.IsValid() {
-			if structE == structVar {
-				C.duk_push_undefined(ctx)
-				return 1
-			}
-			fv = structVar.MethodByName(name)
-			if !fv.IsValid() {
-				C.duk_push_undefined(ctx)
-				return 1
-			}
-		}
-		if fv.CanInterface() {
-			pushGoFunc(ctx, fv.Interface())
+		// methods of a pointer include the ones with value receivers
+		fv = structVar.MethodByName(name)
+		if !fv.IsValid() || !fv.CanInterface() {
+			C.duk_push_undefined(ctx)
 			return 1
 		}
-		C.duk_push_undefined(ctx)
+		pushMethod(ctx, key, fv)
 		return 1
 	}
 	if !fv.CanInterface() {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
-	pushJsProxyValue(ctx, fv.Interface())
+	pushJsProxyValue(ctx, readOnlyIf(fv.Interface(), readOnly))
 	return 1
 }
 
@@ -387,10 +462,7 @@ func go_struct_set(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 		C.duk_push_false(ctx)
 		return 1
 	}
-	if _, ok := goVal.(string); ok {
-		goVal = fmt.Sprintf("%s", goVal) // deep copy
-	}
-	if err = elutils.SetValue(fv, goVal); err != nil {
+	if err = setValue(ctx, fv, goVal); err != nil {
 		C.duk_push_false(ctx)
 		return 1
 	}
@@ -432,7 +504,7 @@ func go_struct_has(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 	return 1
 }
 
-func go_interface_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
+func go_interface_get(ctx *C.duk_context, vv reflect.Value, readOnly bool) C.duk_ret_t {
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -449,12 +521,20 @@ func go_interface_get(ctx *C.duk_context, vv reflect.Value) C.duk_ret_t {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
-	pushGoFunc(ctx, fv.Interface())
+	pushMethod(ctx, key, fv)
 	return 1
 }
 
 //export go_obj_get
 func go_obj_get(ctx *C.duk_context) C.duk_ret_t {
+	ret := go_obj_get_value(ctx)
+	if err := takePushError(ctx); err != nil {
+		return C.DUK_RET_RANGE_ERROR
+	}
+	return ret
+}
+
+func go_obj_get_value(ctx *C.duk_context) C.duk_ret_t {
 	/* 'this' binding: handler
 	 * [0]: target
 	 * [1]: key
@@ -465,19 +545,20 @@ func go_obj_get(ctx *C.duk_context) C.duk_ret_t {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
+	v, readOnly := unwrapReadOnly(v)
 	if v == nil {
 		C.duk_push_undefined(ctx)
 		return 1
 	}
 	switch vv := reflect.ValueOf(v); vv.Kind() {
 	case reflect.Slice, reflect.Array:
-		return go_arr_get(ctx, vv)
+		return go_arr_get(ctx, vv, readOnly)
 	case reflect.Map:
-		return go_map_get(ctx, vv)
+		return go_map_get(ctx, vv, readOnly)
 	case reflect.Struct, reflect.Ptr:
-		return go_struct_get(ctx, vv)
+		return go_struct_get(ctx, vv, readOnly)
 	case reflect.Interface:
-		return go_interface_get(ctx, vv)
+		return go_interface_get(ctx, vv, readOnly)
 	default:
 		C.duk_push_undefined(ctx)
 		return 1
@@ -497,8 +578,9 @@ func go_obj_set(ctx *C.duk_context) C.duk_ret_t {
 		C.duk_push_false(ctx)
 		return 1
 	}
-	if v == nil {
-		C.duk_push_false(ctx)
+	v, readOnly := unwrapReadOnly(v)
+	if v == nil || readOnly || isReadOnlyContext(ctx) {
+		C.duk_push_false(ctx) // TypeError thrown in strict mode
 		return 1
 	}
 	switch vv := reflect.ValueOf(v); vv.Kind() {
@@ -524,6 +606,7 @@ func go_obj_has(ctx *C.duk_context) C.duk_ret_t {
 		C.duk_push_false(ctx)
 		return 1
 	}
+	v, _ = unwrapReadOnly(v)
 	if v == nil {
 		C.duk_push_false(ctx)
 		return 1
@@ -541,6 +624,84 @@ func go_obj_has(ctx *C.duk_context) C.duk_ret_t {
 	}
 }
 
+//export go_obj_delete
+func go_obj_delete(ctx *C.duk_context) C.duk_ret_t {
+	// 'this' binding: handler
+	// [0]: target
+	// [1]: key
+	v, isProxy := getTargetValue(ctx)
+	if !isProxy {
+		C.duk_push_false(ctx)
+		return 1
+	}
+	v, readOnly := unwrapReadOnly(v)
+	if v == nil || readOnly || isReadOnlyContext(ctx) {
+		C.duk_push_false(ctx) // TypeError thrown in strict mode
+		return 1
+	}
+	switch vv := reflect.ValueOf(v); vv.Kind() {
+	case reflect.Map:
+		return go_map_delete(ctx, vv)
+	default:
+		C.duk_push_false(ctx)
+		return 1
+	}
+}
+
+//export go_obj_own_keys
+func go_obj_own_keys(ctx *C.duk_context) C.duk_ret_t {
+	// 'this' binding: handler
+	// [0]: target
+	C.duk_push_array(ctx) // [ target keys ]
+	v, isProxy := getTargetValue(ctx)
+	if !isProxy {
+		return 1
+	}
+	v, _ = unwrapReadOnly(v)
+	if v == nil {
+		return 1
+	}
+
+	var keys []string
+	switch vv := reflect.ValueOf(v); vv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i:=0; i<vv.Len(); i++ {
+			keys = append(keys, strconv.Itoa(i))
+		}
+	case reflect.Map:
+		it := vv.MapRange()
+		for it.Next() {
+			keys = append(keys, mapKeyString(it.Key()))
+		}
+	case reflect.Ptr:
+		if vv.Elem().Kind() != reflect.Struct {
+			break
+		}
+		vv = vv.Elem()
+		fallthrough
+	case reflect.Struct:
+		t := vv.Type()
+		for i:=0; i<t.NumField(); i++ {
+			if ft := t.Field(i); ft.IsExported() {
+				keys = append(keys, lowerFirst(ft.Name))
+			}
+		}
+	}
+
+	for i, key := range keys {
+		// Duktape only lists the keys found as enumerable properties of the target.
+		pushString(ctx, key) // [ target keys key ]
+		if C.duk_has_prop(ctx, 0) == 0 { // [ target keys ]
+			pushString(ctx, key)   // [ target keys key ]
+			C.duk_push_true(ctx)   // [ target keys key true ]
+			C.duk_put_prop(ctx, 0) // [ target keys ] with target[key] = true
+		}
+		pushString(ctx, key)                             // [ target keys key ]
+		C.duk_put_prop_index(ctx, 1, C.duk_uarridx_t(i)) // [ target keys ] with keys[i] = key
+	}
+	return 1
+}
+
 //export goDummyFunc
 func goDummyFunc(ctx *C.duk_context) C.duk_ret_t {
 	return 0
@@ -556,6 +717,10 @@ func go_func_apply(ctx *C.duk_context) C.duk_ret_t {
 	if !isProxy {
 		return C.DUK_RET_ERROR
 	}
+	var names []string
+	if nf, ok := fn.(*namedResultsFunc); ok {
+		fn, names = nf.fn, nf.names
+	}
 	if fn == nil {
 		return C.DUK_RET_ERROR
 	}
@@ -563,36 +728,13 @@ func go_func_apply(ctx *C.duk_context) C.duk_ret_t {
 	if fnVal.Kind() != reflect.Func {
 		return C.DUK_RET_ERROR
 	}
-	fnType := fnVal.Type()
-
-	// make args for Golang function
-	argc := int(C.duk_get_length(ctx, 2))
-	helper := elutils.NewGolangFuncHelperDirectly(fnVal, fnType)
-	getArgs := func(i int) interface{} {
-		C.duk_get_prop_index(ctx, 2, C.duk_uarridx_t(i)) // [ ... i-th arg ]
-		defer C.duk_pop(ctx) // [ ... ]
 
-		if goVal, err := fromJsValue(ctx); err == nil {
-			return goVal
-		}
-		return nil
-	}
-	v, e := helper.CallGolangFunc(argc, "djs-func", getArgs) // call Golang function
-
-	// convert result (in var v) of Golang function to that of JS.
-	// 1. error
-	if e != nil {
-		return C.DUK_RET_ERROR
-	}
-
-	// 2. no result
-	if v == nil {
-		return 0 // undefined
+	results, err := callGoFunc(ctx, fnVal) // call Golang function
+	if err != nil {
+		return throwError(ctx, err)
 	}
-
-	// 3. array or scalar
-	pushJsProxyValue(ctx, v) // [ args ... v ]
-	return 1
+	// convert results of Golang function to that of JS.
+	return pushResults(ctx, results, names)
 }
 
 //export freeTarget
@@ -600,19 +742,32 @@ func freeTarget(ctx *C.duk_context) C.duk_ret_t {
 	// Object being finalized is at stack index 0
 	if idx, isProxy := getTargetIdx(ctx); isProxy {
 		// fmt.Printf("--- freeTarget is called\n")
-		ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
+		ptr := ptrStoreOf(ctx)
 		ptr.remove(idx)
 	}
 	return 0
 }
 
+// ptrStoreOf returns the store of Go values of the heap of ctx.
+func ptrStoreOf(ctx *C.duk_context) *ptrStore {
+	heap := getCtxState(uintptr(unsafe.Pointer(ctx))).heap
+	if heap == 0 {
+		// the main thread of a heap, which runs the finalizers
+		heap = uintptr(unsafe.Pointer(ctx))
+	}
+	return getPtrStore(heap)
+}
+
 func makeProxyObject(ctx *C.duk_context, v interface{}, proxyHandlerName string) {
 	var name *C.char
 
 	// [ target ]
-	ptr := getPtrStore(uintptr(unsafe.Pointer(ctx)))
-	idx := ptr.register(&v)
-	C.duk_push_uint(ctx, C.duk_uint_t(idx)) // [ target idx ]
+	ptr := ptrStoreOf(ctx)
+	idx := ptr.register(v)
+	if getCtxState(uintptr(unsafe.Pointer(ctx))).options.DebugPins {
+		ptr.setSite(idx, pushSite(v))
+	}
+	C.duk_push_number(ctx, C.duk_double_t(idx)) // [ target idx ]
 	getStrPtr(&idxName, &name)
 	C.duk_put_prop_string(ctx, -2, name)  // [ target ] with taget[name] = idx
 
@@ -637,6 +792,9 @@ func pushGoObj(ctx *C.duk_context, v interface{}) {
 
 func pushGoFunc(ctx *C.duk_context, fnVar interface{}) {
 	fnType := reflect.TypeOf(fnVar)
+	if nf, ok := fnVar.(*namedResultsFunc); ok {
+		fnType = reflect.TypeOf(nf.fn)
+	}
 	argc := fnType.NumIn()
 	nargs := C.int(C.DUK_VARARGS)
 	if !fnType.IsVariadic() {
@@ -667,16 +825,21 @@ func registerProxyHandler(ctx *C.duk_context, proxyHandlerName string, trapFuncs
 }
 
 func registerGoProxyHandlers(ctx *C.duk_context) {
+	registerDateClass(ctx)
 	registerProxyHandler(ctx, goObjProxyHandler, &trapFunc{
 		name: get, fn: (C.duk_c_function)(C.go_obj_get), nargs: 3,
 	}, &trapFunc{
 		name: set, fn: (C.duk_c_function)(C.go_obj_set), nargs: 4,
 	}, &trapFunc{
 		name: has, fn: (C.duk_c_function)(C.go_obj_has), nargs: 2,
+	}, &trapFunc{
+		name: deleteProperty, fn: (C.duk_c_function)(C.go_obj_delete), nargs: 2,
+	}, &trapFunc{
+		name: ownKeys, fn: (C.duk_c_function)(C.go_obj_own_keys), nargs: 1,
 	})
 
 	registerProxyHandler(ctx, goFuncProxyHandler, &trapFunc{
-		name: apply, fn: (C.duk_c_function)(C.go_func_apply), nargs: 3,
+		name: apply, fn: (C.duk_c_function)(C.goFuncApply), nargs: 3,
 	})
 }
 
@@ -691,3 +854,6 @@ func upperFirst(name string) string {
 	return strings.ToUpper(name[:1]) + name[1:]
 }
 
+func lowerFirst(name string) string {
+	return strings.ToLower(name[:1]) + name[1:]
+}
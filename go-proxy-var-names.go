@@ -9,5 +9,7 @@ var (
 	get = "get\x00"
 	set = "set\x00"
 	has = "has\x00"
+	deleteProperty = "deleteProperty\x00"
+	ownKeys = "ownKeys\x00"
 	apply = "apply\x00"
 )
@@ -1,12 +1,13 @@
 package djs
 
 import (
+	"reflect"
 	"sync"
 )
 
 type (
-	fnGetPtrStore func(ctx uintptr)(*ptrStore)
-	fnDelPtrStore func(ctx uintptr)
+	fnGetPtrStore func(heap uintptr)(*ptrStore)
+	fnDelPtrStore func(heap uintptr)
 )
 
 var (
@@ -18,105 +19,209 @@ func init() {
 	getPtrStore, delPtrStore = InitPtrStore()
 }
 
+// InitPtrStore makes the functions getting and deleting the store of Go values referred
+// by the JS proxies of a heap. All contexts of a heap share one store, because a proxy
+// can be used by any of them, and it is finalized by the main thread of the heap.
 func InitPtrStore() (getPtrStore fnGetPtrStore, delPtrStore fnDelPtrStore) {
 	lock := &sync.Mutex{}
 	stores := make(map[uintptr]*ptrStore)
 
-	getPtrStore = func(ctx uintptr)(*ptrStore) {
+	getPtrStore = func(heap uintptr)(*ptrStore) {
 		lock.Lock()
 		defer lock.Unlock()
-		if store, ok := stores[ctx]; ok {
+		if store, ok := stores[heap]; ok {
 			return store
 		}
 		store := newPtrStore()
-		stores[ctx] = store
+		stores[heap] = store
 		return store
 	}
 
-	delPtrStore = func(ctx uintptr) {
+	delPtrStore = func(heap uintptr) {
 		lock.Lock()
 		defer lock.Unlock()
-		if store, ok := stores[ctx]; ok {
+		if store, ok := stores[heap]; ok {
 			store.clear()
 		}
-		delete(stores, ctx)
+		delete(stores, heap)
 	}
 
 	return
 }
 
+// ptrHandle refers to a value in a ptrStore, the low 32 bits are the index of the slot,
+// the high bits are the generation of the slot, which is increased when the slot is
+// freed, so a stale handle never refers to the value reusing the slot. A handle is
+// less than 2^53 to be kept as a JS number, 0 is invalid.
+type ptrHandle uint64
+
+const (
+	genBits = 20
+	genMask = 1<<genBits - 1
+)
+
+func makeHandle(i uint32, gen uint32) ptrHandle {
+	return ptrHandle(gen)<<32 | ptrHandle(i)
+}
+
+func (h ptrHandle) split() (i uint32, gen uint32) {
+	return uint32(h), uint32(h>>32) & genMask
+}
+
 type (
-	ref struct {
-		ptr interface{}
-		count int
+	// identity of a value referring to memory, values with the same identity share a slot.
+	identity struct {
+		t reflect.Type
+		p uintptr
+		len, cap int
+	}
+	slot struct {
+		v interface{}
+		id *identity
+		count int // references by proxies, the slot is free if it is 0
+		gen uint32
+		site string // where the value is pushed from, if Options.DebugPins is set
 	}
 	ptrStore struct {
 		lock *sync.Mutex
-		index uint32
-		id2ptr map[uint32]*ref
-		ptr2id map[interface{}]uint32
+		slots []slot // slots[0] is not used
+		free []uint32 // indexes of free slots
+		ids map[identity]uint32
+		closed bool // the heap is destroyed, no value can be registered
 	}
 )
 
 func newPtrStore() *ptrStore {
 	return &ptrStore{
 		lock: &sync.Mutex{},
-		id2ptr: make(map[uint32]*ref),
-		ptr2id: make(map[interface{}]uint32),
+		slots: make([]slot, 1),
+		ids: make(map[identity]uint32),
 	}
 }
 
-func (s *ptrStore) register(i interface{}) uint32 {
+// identityOf returns the identity of pointers, maps, channels and slices. Functions have
+// no identity, different closures may have the same code pointer.
+func identityOf(v interface{}) *identity {
+	vv := reflect.ValueOf(v)
+	switch vv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.UnsafePointer:
+		if vv.IsNil() {
+			return nil
+		}
+		return &identity{t: vv.Type(), p: vv.Pointer()}
+	case reflect.Slice:
+		if vv.IsNil() {
+			return nil
+		}
+		return &identity{t: vv.Type(), p: vv.Pointer(), len: vv.Len(), cap: vv.Cap()}
+	}
+	return nil
+}
+
+// register keeps v until every handle returned for it is removed.
+func (s *ptrStore) register(v interface{}) ptrHandle {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-
-	if index, ok := s.ptr2id[i]; ok {
-		ref, _ := s.id2ptr[index]
-		ref.count += 1
-		return index
+	if s.closed {
+		return 0
 	}
 
-	for {
-		s.index++
-		if _, ok := s.id2ptr[s.index]; !ok {
-			break
+	id := identityOf(v)
+	if id != nil {
+		if i, ok := s.ids[*id]; ok {
+			sl := &s.slots[i]
+			sl.count += 1
+			return makeHandle(i, sl.gen)
 		}
 	}
-	s.id2ptr[s.index] = &ref{ptr:i, count:1}
-	s.ptr2id[i] = s.index
-	return s.index
+
+	var i uint32
+	if n := len(s.free); n > 0 {
+		i = s.free[n-1]
+		s.free = s.free[:n-1]
+	} else {
+		i = uint32(len(s.slots))
+		s.slots = append(s.slots, slot{})
+	}
+	sl := &s.slots[i]
+	sl.v, sl.id, sl.count = v, id, 1
+	if id != nil {
+		s.ids[*id] = i
+	}
+	return makeHandle(i, sl.gen)
+}
+
+// get returns the slot of h, or nil if h is invalid or stale. s must be locked.
+func (s *ptrStore) get(h ptrHandle) *slot {
+	i, gen := h.split()
+	if i == 0 || int(i) >= len(s.slots) {
+		return nil
+	}
+	sl := &s.slots[i]
+	if sl.count == 0 || sl.gen != gen {
+		return nil
+	}
+	return sl
 }
 
-func (s *ptrStore) lookup(i uint32) (ptr interface{}, ok bool) {
+func (s *ptrStore) lookup(h ptrHandle) (v interface{}, ok bool) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if ref, ok1 := s.id2ptr[i]; ok1 {
-		ptr, ok = ref.ptr, true
+	if sl := s.get(h); sl != nil {
+		v, ok = sl.v, true
 	}
 	return
 }
 
-func (s *ptrStore) remove(i uint32) {
+func (s *ptrStore) remove(h ptrHandle) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if ref, ok := s.id2ptr[i]; ok {
-		ref.count -= 1
-		if ref.count > 0 {
-			return
-		}
-
-		delete(s.id2ptr, i)
-		delete(s.ptr2id, ref.ptr)
-		if i <= s.index {
-			s.index = i - 1
-		}
+	sl := s.get(h)
+	if sl == nil {
+		return
 	}
+	if sl.count -= 1; sl.count > 0 {
+		return
+	}
+	if sl.id != nil {
+		delete(s.ids, *sl.id)
+	}
+	i, _ := h.split()
+	*sl = slot{gen: (sl.gen + 1) & genMask}
+	s.free = append(s.free, i)
 }
 
+// clear releases all values when the heap is destroyed.
 func (s *ptrStore) clear() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	s.id2ptr = nil
-	s.ptr2id = nil
+	s.slots = make([]slot, 1)
+	s.free = nil
+	s.ids = make(map[identity]uint32)
+	s.closed = true
+}
+
+func (s *ptrStore) setSite(h ptrHandle, site string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sl := s.get(h); sl != nil && sl.site == "" {
+		sl.site = site
+	}
+}
+
+// stats returns the number of values, and the number of values pushed from every site.
+func (s *ptrStore) stats() (count int, sites map[string]int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	count = len(s.slots) - 1 - len(s.free)
+	for i:=1; i<len(s.slots); i++ {
+		if sl := &s.slots[i]; sl.count > 0 && sl.site != "" {
+			if sites == nil {
+				sites = make(map[string]int)
+			}
+			sites[sl.site] += 1
+		}
+	}
+	return
 }
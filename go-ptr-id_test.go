@@ -0,0 +1,177 @@
+package djs
+
+import (
+	"testing"
+)
+
+func TestPtrStoreStaleHandle(t *testing.T) {
+	s := newPtrStore()
+	h1 := s.register(1)
+	s.remove(h1)
+	if _, ok := s.lookup(h1); ok {
+		t.Fatal("removed handle is found")
+	}
+
+	h2 := s.register(2) // reuses the slot of h1
+	i1, _ := h1.split()
+	i2, _ := h2.split()
+	if i1 != i2 {
+		t.Fatalf("slot %d is not reused, got %d", i1, i2)
+	}
+	if h1 == h2 {
+		t.Fatal("handle of a reused slot is not changed")
+	}
+	if _, ok := s.lookup(h1); ok {
+		t.Fatal("stale handle is found")
+	}
+	s.remove(h1) // no effect
+	if v, ok := s.lookup(h2); !ok || v != 2 {
+		t.Fatalf("got %v %v, want 2", v, ok)
+	}
+	if _, ok := s.lookup(0); ok {
+		t.Fatal("handle 0 is found")
+	}
+}
+
+func TestPtrStoreChurn(t *testing.T) {
+	s := newPtrStore()
+	live := map[ptrHandle]int{}
+	var removed []ptrHandle
+	for round := 0; round < 100; round++ {
+		for i := 0; i < 10; i++ {
+			live[s.register(round*10+i)] = round*10 + i
+		}
+		n := 0
+		for h := range live {
+			if n += 1; n > 7 {
+				break
+			}
+			s.remove(h)
+			delete(live, h)
+			removed = append(removed, h)
+		}
+	}
+	for h, want := range live {
+		if v, ok := s.lookup(h); !ok || v != want {
+			t.Fatalf("got %v %v, want %d", v, ok, want)
+		}
+	}
+	for _, h := range removed {
+		if _, ok := s.lookup(h); ok {
+			t.Fatalf("removed handle %x is found", h)
+		}
+	}
+	if count, _ := s.stats(); count != len(live) {
+		t.Fatalf("got %d values, want %d", count, len(live))
+	}
+	if len(s.slots)-1 > 100*3+10 {
+		t.Fatalf("free slots are not reused, %d slots", len(s.slots)-1)
+	}
+}
+
+func TestPtrStoreGenerationWrap(t *testing.T) {
+	s := newPtrStore()
+	h := s.register(1)
+	i, _ := h.split()
+	s.slots[i].gen = genMask // as if the slot was reused genMask times
+	last := makeHandle(i, genMask)
+	if _, ok := s.lookup(last); !ok {
+		t.Fatal("handle of the last generation is not found")
+	}
+	s.remove(last)
+
+	h = s.register(2)
+	j, gen := h.split()
+	if j != i || gen != 0 {
+		t.Fatalf("got slot %d generation %d, want slot %d generation 0", j, gen, i)
+	}
+	if _, ok := s.lookup(last); ok {
+		t.Fatal("handle of the last generation is found after wrapping")
+	}
+	if v, ok := s.lookup(h); !ok || v != 2 {
+		t.Fatalf("got %v %v, want 2", v, ok)
+	}
+	if max := makeHandle(0xFFFFFFFF, genMask); max >= 1<<53 {
+		t.Fatalf("handle %x is not a safe JS integer", max)
+	}
+}
+
+type identityT struct {
+	a int
+	b int
+}
+
+func TestPtrStoreIdentity(t *testing.T) {
+	s := newPtrStore()
+
+	p := &identityT{}
+	h1, h2 := s.register(p), s.register(p)
+	if h1 != h2 {
+		t.Fatal("same pointer is not deduplicated")
+	}
+	if h := s.register(&p.a); h == h1 {
+		t.Fatal("pointers of different types at the same address share a slot")
+	}
+	s.remove(h1)
+	if v, ok := s.lookup(h2); !ok || v != p {
+		t.Fatal("value referred twice is removed by one remove()")
+	}
+	s.remove(h2)
+	if _, ok := s.lookup(h2); ok {
+		t.Fatal("value is found after all references are removed")
+	}
+
+	m := map[string]int{}
+	if s.register(m) != s.register(m) {
+		t.Fatal("same map is not deduplicated")
+	}
+
+	arr := make([]int, 4, 8)
+	hs := s.register(arr)
+	if s.register(arr) != hs {
+		t.Fatal("same slice is not deduplicated")
+	}
+	if s.register(arr[:2]) == hs {
+		t.Fatal("slices of different lengths share a slot")
+	}
+	if s.register(arr[:4:4]) == hs {
+		t.Fatal("slices of different capacities share a slot")
+	}
+	type ints []int
+	if s.register(ints(arr)) == hs {
+		t.Fatal("slices of different types share a slot")
+	}
+
+	f := func() {}
+	if s.register(f) == s.register(f) {
+		t.Fatal("funcs are deduplicated")
+	}
+	var nilPtr *identityT
+	if s.register(nilPtr) == s.register(nilPtr) {
+		t.Fatal("nil pointers are deduplicated")
+	}
+	if s.register(1) == s.register(1) {
+		t.Fatal("values without identity are deduplicated")
+	}
+}
+
+func TestPtrStoreClosed(t *testing.T) {
+	s := newPtrStore()
+	p := &identityT{}
+	h := s.register(p)
+	s.clear()
+
+	if _, ok := s.lookup(h); ok {
+		t.Fatal("value is found after clear()")
+	}
+	if h = s.register(p); h != 0 {
+		t.Fatalf("register() of a closed store returns %x", h)
+	}
+	if _, ok := s.lookup(h); ok {
+		t.Fatal("handle 0 is found")
+	}
+	s.remove(h) // no effect
+	if count, _ := s.stats(); count != 0 {
+		t.Fatalf("closed store has %d values", count)
+	}
+}
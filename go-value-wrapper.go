@@ -0,0 +1,151 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+type (
+	readOnlyValue struct {
+		v interface{}
+	}
+	frozenValue struct {
+		v interface{}
+	}
+	copiedValue struct {
+		v interface{}
+	}
+)
+
+// ReadOnly makes v exposed to JS as a read-only object, setting or deleting a
+// property of it or of any value got from it is rejected, which throws TypeError
+// in strict mode. Go methods called from JS are not restricted.
+func ReadOnly(v interface{}) interface{} {
+	if _, ok := v.(*readOnlyValue); ok || v == nil {
+		return v
+	}
+	return &readOnlyValue{v: v}
+}
+
+// Frozen makes v exposed to JS as a deep copied and deep frozen plain JS object.
+func Frozen(v interface{}) interface{} {
+	if v == nil {
+		return v
+	}
+	return &frozenValue{v: v}
+}
+
+// Copy makes v exposed to JS as deep copied native JS objects and arrays instead
+// of proxies, so JSON.stringify(), spread and Array.prototype methods work natively
+// and accessing them doesn't cross cgo. Changes made by JS are not synced back to v.
+// Unlike proxies, which name the fields of a struct by the field names with the first
+// letter lowered, copied structs are named by the json tags like decoding results,
+// and fields tagged with "-" are omitted.
+func Copy(v interface{}) interface{} {
+	if v == nil {
+		return v
+	}
+	return &copiedValue{v: v}
+}
+
+func unwrapReadOnly(v interface{}) (interface{}, bool) {
+	if ro, ok := v.(*readOnlyValue); ok {
+		return ro.v, true
+	}
+	if sc, ok := v.(*structCopy); ok {
+		return sc.ptr.Interface(), false
+	}
+	return v, false
+}
+
+// readOnlyIf wraps a value got from a read-only proxy, the wrapper only restricts
+// values pushed as proxies, see pushReadOnlyValue().
+func readOnlyIf(v interface{}, readOnly bool) interface{} {
+	if readOnly {
+		return ReadOnly(v)
+	}
+	return v
+}
+
+func isReadOnlyContext(ctx *C.duk_context) bool {
+	return getCtxState(uintptr(unsafe.Pointer(ctx))).options.ReadOnly
+}
+
+func isBytesAsStringContext(ctx *C.duk_context) bool {
+	return getCtxState(uintptr(unsafe.Pointer(ctx))).options.BytesAsString
+}
+
+func isCopyValuesContext(ctx *C.duk_context) bool {
+	return getCtxState(uintptr(unsafe.Pointer(ctx))).options.CopyValues
+}
+
+// isProxyContainer checks whether v is pushed as a proxy of a map, slice, array or struct,
+// which is restricted by ReadOnly(), other values are pushed as usual.
+func isProxyContainer(ctx *C.duk_context, v interface{}) bool {
+	switch v.(type) {
+	case time.Time, *time.Time, time.Duration, RegExp, *RegExp, specialValue, *JsFunction,
+		*externalBytes, *frozenValue, *copiedValue, *namedResultsFunc:
+		return false
+	}
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	if conv := state.getConverter(reflect.TypeOf(v)); conv != nil && conv.toJS != nil {
+		return false
+	}
+	if state.options.UseMarshalers {
+		switch v.(type) {
+		case json.Marshaler, encoding.TextMarshaler:
+			return false
+		}
+	}
+
+	vv := reflect.ValueOf(v)
+	switch vv.Kind() {
+	case reflect.Slice:
+		return vv.Type().Elem().Kind() != reflect.Uint8
+	case reflect.Array, reflect.Map, reflect.Struct:
+		return true
+	case reflect.Ptr:
+		return !vv.IsNil()
+	}
+	return false
+}
+
+func pushReadOnlyValue(ctx *C.duk_context, ro *readOnlyValue) {
+	v := ro.v
+	if v == nil {
+		C.duk_push_null(ctx)
+		return
+	}
+	if !isProxyContainer(ctx, v) {
+		pushJsProxyValue(ctx, v)
+		return
+	}
+
+	vv := reflect.ValueOf(v)
+	switch vv.Kind() {
+	case reflect.Slice:
+		if vv.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		fallthrough
+	case reflect.Array:
+		pushGoArray(ctx, ro)
+		return
+	case reflect.Map, reflect.Struct, reflect.Interface:
+		pushGoObj(ctx, ro)
+		return
+	case reflect.Ptr:
+		if vv.Elem().Kind() == reflect.Struct {
+			pushGoObj(ctx, ro)
+			return
+		}
+		pushJsProxyValue(ctx, ReadOnly(vv.Elem().Interface()))
+		return
+	}
+	pushJsProxyValue(ctx, v)
+}
@@ -1,5 +1,5 @@
 module github.com/rosbit/dukgo
 
-go 1.20
+go 1.21
 
 require github.com/rosbit/go-embedding-utils v0.4.1
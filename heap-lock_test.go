@@ -0,0 +1,220 @@
+package djs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testGoroutines = 8
+	testRounds     = 200
+)
+
+// evalConcurrently runs Eval with a Go callback in goroutines spread over ctxs.
+func evalConcurrently(t *testing.T, ctxs []*JsContext) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for i := 0; i < testGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := ctxs[i%len(ctxs)]
+			double := func(n int) int { return n * 2 }
+			for j := 0; j < testRounds; j++ {
+				res, err := ctx.Eval("var o = {n: n}; double(o.n) + 1", map[string]interface{}{
+					"n":      j,
+					"double": double,
+				})
+				if err != nil {
+					t.Errorf("goroutine %d: %v", i, err)
+					return
+				}
+				if res != float64(j*2+1) {
+					t.Errorf("goroutine %d: got %v, want %d", i, res, j*2+1)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func newHeapContexts(t *testing.T, h *Heap, n int) (ctxs []*JsContext) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		ctx, err := h.NewContext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctxs = append(ctxs, ctx)
+	}
+	return
+}
+
+func TestConcurrentEvalSharedHeap(t *testing.T) {
+	h, err := NewHeap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	evalConcurrently(t, newHeapContexts(t, h, 4))
+}
+
+func TestConcurrentEvalFreshGlobals(t *testing.T) {
+	h, err := NewHeap(&HeapOptions{FreshGlobals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	evalConcurrently(t, newHeapContexts(t, h, 4))
+}
+
+func TestConcurrentEvalOwnHeaps(t *testing.T) {
+	var ctxs []*JsContext
+	for i := 0; i < 4; i++ {
+		ctx, err := NewContext(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctxs = append(ctxs, ctx)
+	}
+	evalConcurrently(t, ctxs)
+}
+
+// captureFuncs makes every context give a JsFunction multiplying its argument by the index of the context.
+func captureFuncs(t *testing.T, ctxs []*JsContext) (fns []*JsFunction) {
+	t.Helper()
+	for i, ctx := range ctxs {
+		var f *JsFunction
+		script := fmt.Sprintf("(function(x) { return x * %d })", i+1)
+		if err := ctx.EvalInto(script, nil, &f); err != nil {
+			t.Fatal(err)
+		}
+		fns = append(fns, f)
+	}
+	return
+}
+
+func callConcurrently(t *testing.T, fns []*JsFunction) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for i := 0; i < testGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := i % len(fns)
+			for j := 0; j < testRounds; j++ {
+				res, err := fns[k].Call(j)
+				if err != nil {
+					t.Errorf("goroutine %d: %v", i, err)
+					return
+				}
+				if res != float64(j*(k+1)) {
+					t.Errorf("goroutine %d: got %v, want %d", i, res, j*(k+1))
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentJsFunctionCall(t *testing.T) {
+	shared, err := NewHeap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared.Close()
+	fresh, err := NewHeap(&HeapOptions{FreshGlobals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+
+	ctxs := append(newHeapContexts(t, shared, 2), newHeapContexts(t, fresh, 2)...)
+	own, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxs = append(ctxs, own)
+	callConcurrently(t, captureFuncs(t, ctxs))
+}
+
+func TestReentrantCallback(t *testing.T) {
+	h, err := NewHeap(&HeapOptions{FreshGlobals: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	ctxs := newHeapContexts(t, h, 2)
+	other := captureFuncs(t, ctxs)[1] // x * 2 in the other context of the heap
+
+	// Go -> JS -> Go -> JS of the same context and of the other one, on one goroutine.
+	env := map[string]interface{}{
+		"viaGo": func(cb *JsFunction, x int) (interface{}, error) {
+			res, err := cb.Call(x)
+			if err != nil {
+				return nil, err
+			}
+			return other.Call(res)
+		},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < testGoroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < testRounds/4; j++ {
+				res, err := ctxs[0].Eval("viaGo(function(x) { return x + 1 }, n)", map[string]interface{}{
+					"viaGo": env["viaGo"],
+					"n":     j,
+				})
+				if err != nil {
+					t.Errorf("goroutine %d: %v", i, err)
+					return
+				}
+				if res != float64((j+1)*2) {
+					t.Errorf("goroutine %d: got %v, want %d", i, res, (j+1)*2)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestLockNotSharedWithOtherGoroutines(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f *JsFunction
+	if err = ctx.EvalInto("(function() { return 1 })", nil, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	// a goroutine started by a Go function called by JS waits until the call returns.
+	done := make(chan struct{})
+	returned := false
+	_, err = ctx.Eval("spawn()", map[string]interface{}{
+		"spawn": func() {
+			go func() {
+				defer close(done)
+				if _, err := f.Call(); err != nil {
+					t.Error(err)
+				}
+				if !returned {
+					t.Error("function is called while the lock is held by another goroutine")
+				}
+			}()
+			time.Sleep(50 * time.Millisecond)
+			returned = true
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
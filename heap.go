@@ -0,0 +1,321 @@
+package djs
+
+// #include <stdlib.h>
+// #include "duktape.h"
+// typedef struct {
+// 	size_t bytes;  // bytes allocated
+// 	size_t blocks; // blocks allocated
+// } memStats;
+// #define MEM_HDR 16 // the size of a block before it, keeping the alignment of malloc()
+// static void *countedAlloc(void *udata, duk_size_t size) {
+// 	memStats *st = (memStats *)udata;
+// 	char *p = malloc(MEM_HDR + size);
+// 	if (p == NULL) {
+// 		return NULL;
+// 	}
+// 	*(size_t *)p = size;
+// 	st->bytes += size;
+// 	st->blocks += 1;
+// 	return p + MEM_HDR;
+// }
+// static void countedFree(void *udata, void *ptr) {
+// 	memStats *st = (memStats *)udata;
+// 	if (ptr == NULL) {
+// 		return;
+// 	}
+// 	char *p = (char *)ptr - MEM_HDR;
+// 	st->bytes -= *(size_t *)p;
+// 	st->blocks -= 1;
+// 	free(p);
+// }
+// static void *countedRealloc(void *udata, void *ptr, duk_size_t size) {
+// 	memStats *st = (memStats *)udata;
+// 	if (ptr == NULL) {
+// 		return countedAlloc(udata, size);
+// 	}
+// 	if (size == 0) {
+// 		countedFree(udata, ptr);
+// 		return NULL;
+// 	}
+// 	char *p = (char *)ptr - MEM_HDR;
+// 	size_t old = *(size_t *)p;
+// 	p = realloc(p, MEM_HDR + size);
+// 	if (p == NULL) {
+// 		return NULL;
+// 	}
+// 	*(size_t *)p = size;
+// 	st->bytes = st->bytes - old + size;
+// 	return p + MEM_HDR;
+// }
+// static duk_context *createHeap(memStats **mem) {
+// 	duk_context *ctx;
+// 	*mem = calloc(1, sizeof(memStats));
+// 	if (*mem == NULL) {
+// 		return NULL;
+// 	}
+// 	ctx = duk_create_heap(countedAlloc, countedRealloc, countedFree, *mem, NULL);
+// 	if (ctx == NULL) {
+// 		free(*mem);
+// 		*mem = NULL;
+// 	}
+// 	return ctx;
+// }
+import "C"
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// HeapOptions are the options of creating a heap.
+type HeapOptions struct {
+	FreshGlobals bool // every context has its own global object and builtins, or else all contexts share the globals of the heap
+}
+
+// Heap is a Duktape heap, contexts created by it are threads of the heap. Creating a
+// context with shared globals is cheap, setting a global in one context is seen by
+// the others. A context with fresh globals is isolated from the others, except that
+// they share the memory of the heap. A heap is not thread-safe, so all contexts of
+// a heap share one lock, and calls to them from different goroutines are serialized.
+type Heap struct {
+	c *C.duk_context // the main thread
+	mem *heapMem
+	mu *sync.Mutex // guards the fields
+	lock *ctxLock // the lock of all contexts
+	freshGlobals bool
+	contexts map[uintptr]*ctxState // states of the contexts not freed
+	closed bool // also guarded by relLock
+	relLock *sync.Mutex // guards released
+	released []releasedThread // threads of the contexts collected by Go, not released yet
+}
+
+type releasedThread struct {
+	ctx uintptr
+	state *ctxState
+}
+
+var (
+	defaultHeap *Heap // the heap of contexts created by NewContext() without WithoutGlobalHeap
+	threadsName = "\xFFthreads\x00"
+)
+
+func init() {
+	var err error
+	if defaultHeap, err = NewHeap(nil); err != nil {
+		panic("failed to init duktape heap")
+	}
+}
+
+// heapMem counts the memory allocated by a heap.
+type heapMem struct {
+	stats *C.memStats
+}
+
+func createHeap() (ctx *C.duk_context, mem *heapMem) {
+	mem = &heapMem{}
+	ctx = C.createHeap(&mem.stats)
+	return
+}
+
+func destroyHeap(ctx *C.duk_context, mem *heapMem) {
+	C.duk_destroy_heap(ctx) // finalizers of proxies are called
+	C.free(unsafe.Pointer(mem.stats))
+	mem.stats = nil
+	delPtrStore(uintptr(unsafe.Pointer(ctx)))
+	delCtxState(uintptr(unsafe.Pointer(ctx)))
+}
+
+// usage returns the bytes and blocks allocated, the heap must be locked.
+func (mem *heapMem) usage() (bytes int, blocks int) {
+	if mem.stats == nil {
+		return
+	}
+	return int(mem.stats.bytes), int(mem.stats.blocks)
+}
+
+// NewHeap creates a heap, which must be closed by Close() when it is no longer used.
+func NewHeap(options *HeapOptions) (h *Heap, err error) {
+	if options == nil {
+		options = &HeapOptions{}
+	}
+	c, mem := createHeap()
+	if c == (*C.duk_context)(unsafe.Pointer(nil)) {
+		err = fmt.Errorf("failed to create heap")
+		return
+	}
+	if !options.FreshGlobals {
+		loadPreludeModules(c)
+	}
+	h = &Heap{
+		c: c,
+		mem: mem,
+		mu: &sync.Mutex{},
+		lock: &ctxLock{},
+		freshGlobals: options.FreshGlobals,
+		contexts: make(map[uintptr]*ctxState),
+		relLock: &sync.Mutex{},
+	}
+	return
+}
+
+// NewContext creates a context in the heap.
+func (h *Heap) NewContext() (*JsContext, error) {
+	return h.NewContextWithOptions(nil)
+}
+
+// NewContextWithOptions creates a context in the heap with options, Options.WithoutGlobalHeap
+// is ignored, and Options.Sandbox is only applicable to a heap with fresh globals.
+func (h *Heap) NewContextWithOptions(options *Options) (*JsContext, error) {
+	if options == nil {
+		options = &Options{}
+	}
+	if options.Sandbox != nil && !h.freshGlobals {
+		return nil, fmt.Errorf("sandbox is not applicable to a heap with shared globals")
+	}
+	ctx, err := h.newThread()
+	if err != nil {
+		return nil, err
+	}
+	return newJsContext(ctx, h, h.mem, h.lock, options)
+}
+
+// newThread pushes a thread kept in the stash of the heap until the context is freed.
+func (h *Heap) newThread() (ctx *C.duk_context, err error) {
+	// h.lock is locked before h.mu, as in a Go function called by JS.
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		err = fmt.Errorf("heap is closed")
+		return
+	}
+	h.releaseQueued()
+
+	c := h.c
+	var flags C.duk_uint_t
+	if h.freshGlobals {
+		flags = C.DUK_THREAD_NEW_GLOBAL_ENV
+	}
+	C.duk_push_thread_raw(c, flags) // [ thread ]
+	ctx = C.duk_get_context(c, -1)
+	if ctx == (*C.duk_context)(unsafe.Pointer(nil)) {
+		C.duk_pop(c)
+		err = fmt.Errorf("failed to create context")
+		return
+	}
+	h.threads()                                   // [ thread threads ]
+	pushString(c, strconv.FormatUint(uint64(uintptr(unsafe.Pointer(ctx))), 10)) // [ thread threads key ]
+	C.duk_dup(c, -3)                              // [ thread threads key thread ]
+	C.duk_put_prop(c, -3)                         // [ thread threads ] with threads[key] = thread
+	C.duk_pop_2(c)                                // [ ]
+
+	if h.freshGlobals {
+		loadPreludeModules(ctx)
+	}
+	h.contexts[uintptr(unsafe.Pointer(ctx))] = getCtxState(uintptr(unsafe.Pointer(ctx)))
+	return
+}
+
+// threads pushes the object keeping the threads in the stash.
+func (h *Heap) threads() {
+	var name *C.char
+	getStrPtr(&threadsName, &name)
+	C.duk_push_global_stash(h.c) // [ stash ]
+	if C.duk_get_prop_string(h.c, -1, name) == 0 { // [ stash threads ]
+		C.duk_pop(h.c)                         // [ stash ]
+		C.duk_push_bare_object(h.c)            // [ stash threads ]
+		C.duk_dup(h.c, -1)                     // [ stash threads threads ]
+		C.duk_put_prop_string(h.c, -3, name) // [ stash threads ] with stash[threadsName] = threads
+	}
+	C.duk_remove(h.c, -2) // [ threads ]
+}
+
+// freeThread lets the thread of a freed context be garbage collected.
+func (h *Heap) freeThread(ctx *C.duk_context, state *ctxState) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.releaseQueued()
+	h.releaseThread(uintptr(unsafe.Pointer(ctx)), state)
+}
+
+// addReleasedThread queues the thread of a context collected by Go, it is called by
+// the finalizer which must not block.
+func (h *Heap) addReleasedThread(ctx *C.duk_context, state *ctxState) {
+	h.relLock.Lock()
+	defer h.relLock.Unlock()
+	if h.closed {
+		return // threads are destroyed with the heap
+	}
+	h.released = append(h.released, releasedThread{uintptr(unsafe.Pointer(ctx)), state})
+}
+
+// releaseThreads releases the queued threads, h.lock must be locked.
+func (h *Heap) releaseThreads() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.releaseQueued()
+}
+
+// releaseQueued releases the queued threads, h.lock and h.mu must be locked.
+func (h *Heap) releaseQueued() {
+	h.relLock.Lock()
+	threads := h.released
+	h.released = nil
+	h.relLock.Unlock()
+
+	for _, t := range threads {
+		h.releaseThread(t.ctx, t.state)
+	}
+}
+
+// releaseThread removes the thread from the stash, h.lock and h.mu must be locked.
+func (h *Heap) releaseThread(ctx uintptr, state *ctxState) {
+	if h.contexts[ctx] != state {
+		return // freed already
+	}
+	state.closed = true // JsFunction-s are not usable
+	delete(h.contexts, ctx)
+	delCtxStateIf(ctx, state)
+
+	c := h.c
+	h.threads() // [ threads ]
+	pushString(c, strconv.FormatUint(uint64(ctx), 10)) // [ threads key ]
+	C.duk_del_prop(c, -2) // [ threads ]
+	C.duk_pop(c)          // [ ]
+}
+
+// Close destroys the heap, methods of its contexts and JsFunction-s of them
+// return errors after closing, Stats() of the contexts returns zero.
+func (h *Heap) Close() {
+	if h == defaultHeap {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+
+	for c, state := range h.contexts {
+		state.closed = true
+		delCtxStateIf(c, state) // threads are destroyed with the heap
+	}
+	h.contexts = nil
+	destroyHeap(h.c, h.mem)
+	h.relLock.Lock()
+	h.released = nil
+	h.closed = true
+	h.relLock.Unlock()
+}
@@ -0,0 +1,65 @@
+package djs
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func (h *Heap) releasedCount() int {
+	h.relLock.Lock()
+	defer h.relLock.Unlock()
+	return len(h.released)
+}
+
+func (h *Heap) contextCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.contexts)
+}
+
+func TestFinalizerNotBlockedByHeapLock(t *testing.T) {
+	h, err := NewHeap(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	ctx, err := h.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		if _, err := h.NewContext(); err != nil { // garbage when returned
+			t.Fatal(err)
+		}
+	}()
+
+	// the heap is locked while the Go function runs, the finalizer queues the thread anyway.
+	queued := false
+	_, err = ctx.Eval("collect()", map[string]interface{}{
+		"collect": func() {
+			deadline := time.Now().Add(5 * time.Second)
+			for h.releasedCount() == 0 && time.Now().Before(deadline) {
+				runtime.GC()
+				time.Sleep(time.Millisecond)
+			}
+			queued = h.releasedCount() > 0
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queued {
+		t.Fatal("thread of the collected context is not queued")
+	}
+
+	if _, err = ctx.Eval("1", nil); err != nil { // locks the heap again
+		t.Fatal(err)
+	}
+	if n := h.releasedCount(); n != 0 {
+		t.Fatalf("%d threads are not released", n)
+	}
+	if n := h.contextCount(); n != 1 {
+		t.Fatalf("got %d contexts, want 1", n)
+	}
+}
@@ -1,74 +1,336 @@
 package djs
 
 import (
+	"container/list"
+	"fmt"
 	"sync"
 	"os"
 	"time"
 )
 
+// VarsMode decides how the vars passed to LoadFile() are used by a cached context.
+type VarsMode int
+const (
+	// vars are set only when the script is loaded (or reloaded), later callers share the
+	// globals of the first caller.
+	VarsOnLoad VarsMode = iota
+	// vars are set again on every cache hit. globals set by the vars of the previous caller
+	// but absent in the current vars are deleted, globals created by the script persist.
+	// The context is shared, so reapplying overwrites the globals of a context which
+	// previous callers may still be using.
+	VarsReapply
+)
+
+type CacheConfig struct {
+	MaxEntries int           // max number of cached contexts, the least recently used one is evicted. 0 means no limit
+	TTL        time.Duration // a cached context older than TTL is reloaded. 0 means no expiration
+	VarsMode   VarsMode
+}
+
+type CacheStats struct {
+	Entries   int
+	Hits      uint64
+	Misses    uint64
+	Reloads   uint64
+	Evictions uint64
+}
+
+type fileSig struct {
+	mt    time.Time
+	hash  string
+	modID string // empty for the script file
+}
+
 type jsCtx struct {
-	jsvm *JsContext
-	mt   time.Time
+	key       string // path, or path with a variant id
+	path      string
+	vars      map[string]interface{}
+	fromGlobalHeap bool
+	jsvm      *JsContext
+	deps      map[string]*fileSig // the script file and the module files loaded by it
+	loadedAt  time.Time
+	reloading bool
+	stale     bool // set by the watcher
+	pending   bool // a dependent file changed while reloading
+	elem      *list.Element
+}
+
+// Cache caches contexts created by evaluating script files, the context
+// is reloaded when the file or any module file required by it is modified.
+type Cache struct {
+	conf    CacheConfig
+	lock    *sync.Mutex
+	entries map[string]*jsCtx
+	lru     *list.List // front is the most recently used
+	stats   CacheStats
+
+	watchConf *WatchConfig
+	watcher   fileWatcher
+	watched   map[string]map[*jsCtx]struct{} // absolute path of dependent file -> entries
+}
+
+func NewCache(conf CacheConfig) *Cache {
+	return &Cache{
+		conf: conf,
+		lock: &sync.Mutex{},
+		entries: make(map[string]*jsCtx),
+		lru: list.New(),
+	}
 }
 
 var (
-	jsCtxCache map[string]*jsCtx
-	lock *sync.Mutex
+	defaultCache *Cache
+	defaultCacheOnce = &sync.Once{}
 )
 
+func getDefaultCache() *Cache {
+	defaultCacheOnce.Do(func() {
+		defaultCache = NewCache(CacheConfig{})
+	})
+	return defaultCache
+}
+
+// InitCache is kept for compatibility, the default cache is initialized when it is used.
 func InitCache() {
-	if lock != nil {
-		return
-	}
-	lock = &sync.Mutex{}
-	jsCtxCache = make(map[string]*jsCtx)
+	getDefaultCache()
 }
 
 func LoadFileFromCache(path string, vars map[string]interface{}, withGlobalHeap ...bool) (ctx *JsContext, existing bool, err error) {
-	fromGlobalHeap := len(withGlobalHeap) > 0 && withGlobalHeap[0]
-	lock.Lock()
-	defer lock.Unlock()
+	return getDefaultCache().LoadFile(path, vars, withGlobalHeap...)
+}
+
+func LoadFileVariantFromCache(path string, variant string, vars map[string]interface{}, withGlobalHeap ...bool) (ctx *JsContext, existing bool, err error) {
+	return getDefaultCache().LoadFileVariant(path, variant, vars, withGlobalHeap...)
+}
+
+func InvalidateCache(path string) {
+	getDefaultCache().Invalidate(path)
+}
+
+func PurgeCache() {
+	getDefaultCache().Purge()
+}
+
+func GetCacheStats() CacheStats {
+	return getDefaultCache().Stats()
+}
 
-	jsC, ok := jsCtxCache[path]
+func (c *Cache) LoadFile(path string, vars map[string]interface{}, withGlobalHeap ...bool) (ctx *JsContext, existing bool, err error) {
+	return c.LoadFileVariant(path, "", vars, withGlobalHeap...)
+}
+
+// LoadFileVariant caches a context for every variant of path, callers with different
+// vars should use different variant ids to get contexts with their own globals.
+func (c *Cache) LoadFileVariant(path string, variant string, vars map[string]interface{}, withGlobalHeap ...bool) (ctx *JsContext, existing bool, err error) {
+	fromGlobalHeap := len(withGlobalHeap) > 0 && withGlobalHeap[0]
+	key := path
+	if len(variant) > 0 {
+		key = fmt.Sprintf("%s\x00%s", path, variant)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
 
+	jsC, ok := c.entries[key]
 	if !ok {
-		if ctx, err = createJSContext(path, vars, fromGlobalHeap); err != nil {
+		c.stats.Misses += 1
+		var deps map[string]*fileSig
+		if ctx, deps, err = loadJSContext(path, vars, fromGlobalHeap, nil); err != nil {
 			return
 		}
-		fi, _ := os.Stat(path)
 		jsC = &jsCtx{
+			key: key,
+			path: path,
+			vars: vars,
+			fromGlobalHeap: fromGlobalHeap,
 			jsvm: ctx,
-			mt: fi.ModTime(),
+			deps: deps,
+			loadedAt: time.Now(),
 		}
-		jsCtxCache[path] = jsC
+		jsC.elem = c.lru.PushFront(jsC)
+		c.entries[key] = jsC
+		c.watchDeps(jsC)
+		c.evict()
 		return
 	}
 
-	fi, e := os.Stat(path)
-	if e != nil {
-		err = e
-		return
-	}
-	mt := fi.ModTime()
-	if !jsC.mt.Equal(mt) {
-		if ctx, err = createJSContext(path, vars, fromGlobalHeap); err != nil {
-			return
-		}
-		jsC.jsvm = ctx
-		jsC.mt = mt
+	c.lru.MoveToFront(jsC.elem)
+	expired := c.conf.TTL > 0 && time.Since(jsC.loadedAt) >= c.conf.TTL
+	var changed bool
+	if c.watcher != nil {
+		changed = jsC.stale
 	} else {
+		changed = depsChanged(jsC.deps)
+	}
+	if jsC.reloading || !(expired || changed) {
+		// the cached context is used until a reloading one is ready.
+		c.stats.Hits += 1
 		existing = true
 		ctx = jsC.jsvm
+		if c.conf.VarsMode == VarsReapply {
+			err = ctx.resetEnv(vars, jsC.vars)
+			jsC.vars = vars // globals of vars partially set are deleted by the next caller
+			if err != nil {
+				ctx = nil
+			}
+		}
+		return
+	}
+
+	// reload without holding the lock, callers holding the old context are not disturbed.
+	c.stats.Reloads += 1
+	jsC.reloading = true
+	c.lock.Unlock()
+	ctx, deps, err := loadJSContext(path, vars, fromGlobalHeap, jsC.deps)
+	c.lock.Lock()
+	defer c.finishReload(jsC)
+	if c.entries[key] != jsC {
+		// invalidated or evicted while reloading, the new context is not cached.
+		return
+	}
+	if err != nil {
+		if c.watcher != nil {
+			// keep serving the previous version, the failure is reported by OnReload.
+			c.reportReload(path, err)
+			ctx, existing, err = jsC.jsvm, true, nil
+		}
+		return
+	}
+	jsC.vars = vars
+	jsC.fromGlobalHeap = fromGlobalHeap
+	jsC.jsvm = ctx
+	jsC.deps = deps
+	jsC.loadedAt = time.Now()
+	c.watchDeps(jsC)
+	if c.watcher != nil {
+		c.reportReload(path, nil)
+	}
+	return
+}
+
+func (c *Cache) evict() {
+	if c.conf.MaxEntries <= 0 {
+		return
 	}
+	for c.lru.Len() > c.conf.MaxEntries {
+		jsC := c.lru.Remove(c.lru.Back()).(*jsCtx)
+		delete(c.entries, jsC.key)
+		c.unwatchDeps(jsC, nil)
+		c.stats.Evictions += 1
+	}
+}
+
+// Invalidate removes the cached contexts of all variants of path, they will be reloaded when loaded next time.
+func (c *Cache) Invalidate(path string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, jsC := range c.entries {
+		if jsC.path != path {
+			continue
+		}
+		c.lru.Remove(jsC.elem)
+		delete(c.entries, key)
+		c.unwatchDeps(jsC, nil)
+	}
+}
+
+// Purge removes all cached contexts.
+func (c *Cache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries = make(map[string]*jsCtx)
+	c.lru.Init()
+	for path, _ := range c.watched {
+		c.watcher.remove(path)
+	}
+	if c.watched != nil {
+		c.watched = make(map[string]map[*jsCtx]struct{})
+	}
+}
+
+func (c *Cache) Stats() (stats CacheStats) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats = c.stats
+	stats.Entries = len(c.entries)
 	return
 }
 
-func createJSContext(path string, vars map[string]interface{}, fromGlobalHeap bool) (ctx *JsContext, err error) {
+func createJSContext(path string, vars map[string]interface{}, fromGlobalHeap bool, staleMods ...string) (ctx *JsContext, err error) {
 	if ctx, err = NewContext(!fromGlobalHeap); err != nil {
 		return
 	}
+	ctx.forgetModules(staleMods)
 	if _, err = ctx.EvalFile(path, vars); err != nil {
 		return
 	}
 	return
 }
+
+// loadJSContext creates a context by evaluating path, modules in oldDeps are
+// required again instead of being got from Duktape.modLoaded of the global heap.
+func loadJSContext(path string, vars map[string]interface{}, fromGlobalHeap bool, oldDeps map[string]*fileSig) (ctx *JsContext, deps map[string]*fileSig, err error) {
+	sig, e := getFileSig(path)
+	if e != nil {
+		err = e
+		return
+	}
+	var staleMods []string
+	if fromGlobalHeap {
+		for _, dep := range oldDeps {
+			if len(dep.modID) > 0 {
+				staleMods = append(staleMods, dep.modID)
+			}
+		}
+	}
+	if ctx, err = createJSContext(path, vars, fromGlobalHeap, staleMods...); err != nil {
+		return
+	}
+
+	deps = map[string]*fileSig{path: sig}
+	for modPath, mod := range ctx.modFiles() {
+		fi, e := os.Stat(modPath)
+		if e != nil {
+			continue
+		}
+		deps[modPath] = &fileSig{mt: fi.ModTime(), hash: mod.hash, modID: mod.id}
+	}
+	return
+}
+
+func getFileSig(path string) (sig *fileSig, err error) {
+	fi, e := os.Stat(path)
+	if e != nil {
+		err = e
+		return
+	}
+	b, e := os.ReadFile(path)
+	if e != nil {
+		err = e
+		return
+	}
+	sig = &fileSig{mt: fi.ModTime(), hash: hashContent(b)}
+	return
+}
+
+// depsChanged checks the modification time of every dependent file, and
+// the content hash only if the modification time changed.
+func depsChanged(deps map[string]*fileSig) bool {
+	for path, sig := range deps {
+		fi, e := os.Stat(path)
+		if e != nil {
+			return true
+		}
+		if fi.ModTime().Equal(sig.mt) {
+			continue
+		}
+		b, e := os.ReadFile(path)
+		if e != nil || hashContent(b) != sig.hash {
+			return true
+		}
+		sig.mt = fi.ModTime() // touched only
+	}
+	return false
+}
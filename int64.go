@@ -0,0 +1,215 @@
+package djs
+
+// #include "duktape.h"
+// static const char *getCString(duk_context *ctx, duk_idx_t idx);
+// static duk_ret_t unsafeNew(duk_context *ctx, void *udata) {
+// 	(void)udata;
+// 	// [ ... constructor arg ]
+// 	duk_new(ctx, 1); // [ ... obj ]
+// 	return 1;
+// }
+// static duk_bool_t safeNew(duk_context *ctx) {
+// 	// [ ... constructor arg ] -> [ ... obj/error ]
+// 	return duk_safe_call(ctx, unsafeNew, NULL, 2, 1) == DUK_EXEC_SUCCESS;
+// }
+import "C"
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"unsafe"
+)
+
+// Int64Mode decides how Go integers out of the safe range of JS numbers
+// ([-(2^53-1), 2^53-1]) are pushed to JS.
+type Int64Mode int
+const (
+	Int64AsNumber     Int64Mode = iota // converted to number, precision may be lost
+	Int64AsString                      // converted to decimal string
+	Int64AsBoxed                       // converted to an Int64 object, see int64Script
+	Int64ErrorIfLossy                  // converting fails with an error
+)
+
+const (
+	maxSafeInteger = 1<<53 - 1
+	minSafeInteger = -maxSafeInteger
+)
+
+var (
+	int64ClassName = "\xFFInt64\x00"
+	int64ValueName = "value\x00"
+)
+
+// Int64 objects keep integers in decimal string, with arithmetic helpers implemented in Go.
+// The builtins used by the constructor are captured, so scripts altering them can't break it.
+const int64Script = `(function(ops, hiddenName) {
+	var g = this, Str = String, Num = Number, defProp = Object.defineProperty;
+	function toStr(v) {
+		return (v instanceof Int64) ? v.value : ops.norm(Str(v));
+	}
+	function Int64(v) {
+		if (!(this instanceof Int64)) {
+			return new Int64(v);
+		}
+		defProp(this, 'value', {value: toStr(v), enumerable: true});
+	}
+	Int64.prototype.toString = function() { return this.value; };
+	Int64.prototype.toJSON = function() { return this.value; };
+	Int64.prototype.valueOf = function() { return Num(this.value); };
+	['add', 'sub', 'mul', 'div', 'mod'].forEach(function(op) {
+		Int64.prototype[op] = function(o) { return new Int64(ops[op](this.value, toStr(o))); };
+	});
+	Int64.prototype.cmp = function(o) { return ops.cmp(this.value, toStr(o)); };
+	Int64.prototype.eq = function(o) { return this.cmp(o) == 0; };
+	Int64.prototype.lt = function(o) { return this.cmp(o) < 0; };
+	Int64.prototype.gt = function(o) { return this.cmp(o) > 0; };
+	Int64.isInt64 = function(v) { return v instanceof Int64; };
+	defProp(g, 'Int64', {value: Int64, writable: true, configurable: true});
+	g[hiddenName] = Int64;
+})`
+
+func parseBigInt(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid integer %q", s)
+	}
+	return n, nil
+}
+
+func int64Op(op func(z, x, y *big.Int) *big.Int) func(a, b string) (string, error) {
+	return func(a, b string) (string, error) {
+		x, err := parseBigInt(a)
+		if err != nil {
+			return "", err
+		}
+		y, err := parseBigInt(b)
+		if err != nil {
+			return "", err
+		}
+		if op == nil {
+			return "", nil
+		}
+		return op(new(big.Int), x, y).String(), nil
+	}
+}
+
+var int64Ops = map[string]interface{}{
+	"norm": func(s string) (string, error) {
+		n, err := parseBigInt(s)
+		if err != nil {
+			return "", err
+		}
+		return n.String(), nil
+	},
+	"add": int64Op((*big.Int).Add),
+	"sub": int64Op((*big.Int).Sub),
+	"mul": int64Op((*big.Int).Mul),
+	"div": int64Op(func(z, x, y *big.Int) *big.Int {
+		if y.Sign() == 0 {
+			return z.SetInt64(0)
+		}
+		return z.Quo(x, y)
+	}),
+	"mod": int64Op(func(z, x, y *big.Int) *big.Int {
+		if y.Sign() == 0 {
+			return z.SetInt64(0)
+		}
+		return z.Rem(x, y)
+	}),
+	"cmp": func(a, b string) (int, error) {
+		x, err := parseBigInt(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := parseBigInt(b)
+		if err != nil {
+			return 0, err
+		}
+		return x.Cmp(y), nil
+	},
+}
+
+// registerInt64Class defines the global class Int64 when the Int64Mode is Int64AsBoxed.
+func (ctx *JsContext) registerInt64Class() (err error) {
+	_, err = ctx.callScriptFunc(int64Script, nil, ReadOnly(int64Ops), int64ClassName[:len(int64ClassName)-1])
+	return
+}
+
+func getInt64Mode(ctx *C.duk_context) Int64Mode {
+	return getCtxState(uintptr(unsafe.Pointer(ctx))).options.Int64Mode
+}
+
+func pushInt64(ctx *C.duk_context, i int64) {
+	if i >= minSafeInteger && i <= maxSafeInteger {
+		C.duk_push_number(ctx, C.duk_double_t(i))
+		return
+	}
+	pushLargeInteger(ctx, strconv.FormatInt(i, 10), float64(i))
+}
+
+func pushUint64(ctx *C.duk_context, u uint64) {
+	if u <= maxSafeInteger {
+		C.duk_push_number(ctx, C.duk_double_t(u))
+		return
+	}
+	pushLargeInteger(ctx, strconv.FormatUint(u, 10), float64(u))
+}
+
+func pushLargeInteger(ctx *C.duk_context, s string, f float64) {
+	switch getInt64Mode(ctx) {
+	case Int64AsString:
+		pushString(ctx, s)
+	case Int64AsBoxed:
+		var name *C.char
+		getStrPtr(&int64ClassName, &name)
+		if C.duk_get_global_string(ctx, name) == 0 { // [ Int64 ]
+			C.duk_pop(ctx)
+			pushString(ctx, s)
+			return
+		}
+		pushString(ctx, s)  // [ Int64 s ]
+		if C.safeNew(ctx) == 0 { // [ Int64(s)/error ]
+			setPushError(ctx, fmt.Errorf("failed to create Int64 of %s: %s", s, C.GoString(C.getCString(ctx, -1))))
+			C.duk_pop(ctx)
+			C.duk_push_undefined(ctx)
+		}
+	case Int64ErrorIfLossy:
+		setPushError(ctx, fmt.Errorf("integer %s cannot be converted to number without losing precision", s))
+		C.duk_push_undefined(ctx)
+	default:
+		C.duk_push_number(ctx, C.duk_double_t(f))
+	}
+}
+
+// fromInt64Obj converts an Int64 object to int64, or uint64, or string if it is out of range.
+func fromInt64Obj(ctx *C.duk_context) (goVal interface{}, isInt64 bool) {
+	// [ ... obj ]
+	var name *C.char
+	getStrPtr(&int64ClassName, &name)
+	if C.duk_get_global_string(ctx, name) == 0 { // [ ... obj Int64 ]
+		C.duk_pop(ctx) // [ ... obj ]
+		return
+	}
+	isInt64 = C.duk_instanceof(ctx, -2, -1) != 0
+	C.duk_pop(ctx) // [ ... obj ]
+	if !isInt64 {
+		return
+	}
+
+	getStrPtr(&int64ValueName, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ ... obj value ]
+	s := C.GoString(C.getCString(ctx, -1))
+	C.duk_pop(ctx) // [ ... obj ]
+	goVal = parseInteger(s)
+	return
+}
+
+func parseInteger(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,254 @@
+package djs
+
+// #include "duktape.h"
+// static const char *getCString(duk_context *ctx, duk_idx_t idx);
+import "C"
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// KeyValue is an entry of a JS Map with keys other than strings.
+type KeyValue struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// RegExp is converted from/to a JS RegExp object.
+type RegExp struct {
+	Source string
+	Flags  string
+}
+
+var (
+	jsClassHelperName = "\xFFclassHelper\x00"
+	objectProtoName   = "objectProto\x00"
+	classOfName       = "classOf\x00"
+	entriesName       = "entries\x00"
+	valuesName        = "values\x00"
+	regExpName        = "regExp\x00"
+	sourceName        = "source\x00"
+	flagsName         = "flags\x00"
+)
+
+// the builtins are captured when a context is created, they are still usable after
+// being removed or altered by scripts. Map and Set are not builtins of Duktape, they
+// are converted if a polyfill provides them with forEach() and Symbol.toStringTag.
+const jsClassScript = `(function(hiddenName) {
+	var g = this, toString = Object.prototype.toString, RE = RegExp;
+	g[hiddenName] = {
+		objectProto: Object.prototype,
+		classOf: function(o) { return toString.call(o); },
+		entries: function(m) { var r = []; m.forEach(function(v, k) { r.push([k, v]); }); return r; },
+		values: function(s) { var r = []; s.forEach(function(v) { r.push(v); }); return r; },
+		regExp: function(source, flags) { return new RE(source, flags); }
+	};
+})`
+
+func (ctx *JsContext) registerClassHelper() (err error) {
+	_, err = ctx.callScriptFunc(jsClassScript, nil, jsClassHelperName[:len(jsClassHelperName)-1])
+	return
+}
+
+// callClassHelper calls the helper function fnName with the args on the top of the stack.
+func callClassHelper(ctx *C.duk_context, fnName string, args int) (err error) {
+	// [ ... arg1 ... argN ]
+	var name *C.char
+	getStrPtr(&jsClassHelperName, &name)
+	if C.duk_get_global_string(ctx, name) == 0 { // [ ... args helper ]
+		C.duk_pop_n(ctx, C.duk_idx_t(args+1))
+		C.duk_push_undefined(ctx)
+		err = fmt.Errorf("class helper not found")
+		return
+	}
+	C.duk_insert(ctx, C.duk_idx_t(-args-1)) // [ ... helper args ]
+	getStrPtr(&fnName, &name)
+	C.duk_push_string(ctx, name)            // [ ... helper args fnName ]
+	C.duk_insert(ctx, C.duk_idx_t(-args-1)) // [ ... helper fnName args ]
+	if C.duk_pcall_prop(ctx, C.duk_idx_t(-args-2), C.duk_idx_t(args)) != 0 { // [ ... helper result/error ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(ctx, -1)))
+	}
+	C.duk_remove(ctx, -2) // [ ... result/error ]
+	return
+}
+
+// objClass returns the class of the object on the top of the stack, such as "RegExp" and
+// "Uint32Array", or "Object" for plain objects.
+func objClass(ctx *C.duk_context) string {
+	// [ ... obj ]
+	var name *C.char
+	C.duk_get_prototype(ctx, -1) // [ ... obj proto ]
+	if C.duk_is_undefined(ctx, -1) != 0 {
+		C.duk_pop(ctx)
+		return "Object"
+	}
+	getStrPtr(&jsClassHelperName, &name)
+	if C.duk_get_global_string(ctx, name) == 0 { // [ ... obj proto helper ]
+		C.duk_pop_2(ctx)
+		return "Object"
+	}
+	getStrPtr(&objectProtoName, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ ... obj proto helper objectProto ]
+	plain := C.duk_strict_equals(ctx, -1, -3) != 0
+	C.duk_pop_3(ctx) // [ ... obj ]
+	if plain {
+		return "Object"
+	}
+
+	C.duk_dup(ctx, -1) // [ ... obj obj ]
+	if err := callClassHelper(ctx, classOfName, 1); err != nil { // [ ... obj class ]
+		C.duk_pop(ctx)
+		return "Object"
+	}
+	class := C.GoString(C.getCString(ctx, -1))
+	C.duk_pop(ctx) // [ ... obj ]
+	return strings.TrimSuffix(strings.TrimPrefix(class, "[object "), "]")
+}
+
+// fromBufferObj converts a typed array to a Go slice of the matching element type, other buffer objects to []byte.
+func fromBufferObj(ctx *C.duk_context) interface{} {
+	// [ ... buffer-obj ]
+	var length C.size_t
+	b := C.duk_get_buffer_data(ctx, -1, &length)
+	switch objClass(ctx) {
+	case "Int8Array":
+		return typedSlice[int8](b, length)
+	case "Int16Array":
+		return typedSlice[int16](b, length)
+	case "Uint16Array":
+		return typedSlice[uint16](b, length)
+	case "Int32Array":
+		return typedSlice[int32](b, length)
+	case "Uint32Array":
+		return typedSlice[uint32](b, length)
+	case "Float32Array":
+		return typedSlice[float32](b, length)
+	case "Float64Array":
+		return typedSlice[float64](b, length)
+	default:
+		return fromBuffer(ctx, b, length)
+	}
+}
+
+// typedSlice copies the elements of a typed array, which are in the native byte order.
+func typedSlice[T int8|int16|uint16|int32|uint32|float32|float64](b unsafe.Pointer, length C.size_t) []T {
+	var e T
+	size := int(unsafe.Sizeof(e))
+	n := int(length) / size
+	res := make([]T, n)
+	if n > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&res[0])), n*size), unsafe.Slice((*byte)(b), n*size))
+	}
+	return res
+}
+
+func fromRegExpObj(ctx *C.duk_context) RegExp {
+	// [ ... regexp ]
+	var name *C.char
+	getStrPtr(&sourceName, &name)
+	C.duk_get_prop_string(ctx, -1, name) // [ ... regexp source ]
+	getStrPtr(&flagsName, &name)
+	C.duk_get_prop_string(ctx, -2, name) // [ ... regexp source flags ]
+	defer C.duk_pop_2(ctx)               // [ ... regexp ]
+	return RegExp{
+		Source: C.GoString(C.getCString(ctx, -2)),
+		Flags: C.GoString(C.getCString(ctx, -1)),
+	}
+}
+
+func pushRegExp(ctx *C.duk_context, re *RegExp) {
+	pushString(ctx, re.Source) // [ source ]
+	pushString(ctx, re.Flags)  // [ source flags ]
+	if err := callClassHelper(ctx, regExpName, 2); err != nil { // [ regexp/error ]
+		setPushError(ctx, err)
+	}
+}
+
+// fromMapObj converts a Map to map[string]interface{} if all keys are strings, or []KeyValue.
+func (st *fromJsState) fromMapObj(ctx *C.duk_context, ptr unsafe.Pointer) (goVal interface{}, err error) {
+	// [ ... map ]
+	C.duk_dup(ctx, -1) // [ ... map map ]
+	if err = callClassHelper(ctx, entriesName, 1); err != nil { // [ ... map entries ]
+		C.duk_pop(ctx)
+		return
+	}
+	defer C.duk_pop(ctx) // [ ... map ]
+
+	length := int(C.duk_get_length(ctx, -1))
+	if err = st.addElements(length); err != nil {
+		return
+	}
+	strKeys := true
+	for i:=0; i<length && strKeys; i++ {
+		C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(i)) // [ ... map entries entry ]
+		C.duk_get_prop_index(ctx, -1, 0)                  // [ ... map entries entry key ]
+		strKeys = C.duk_is_string(ctx, -1) != 0 && C.duk_is_symbol(ctx, -1) == 0
+		C.duk_pop_2(ctx) // [ ... map entries ]
+	}
+
+	var res map[string]interface{}
+	var kvs []KeyValue
+	if strKeys {
+		res = make(map[string]interface{}, length)
+		goVal = res
+	} else {
+		kvs = make([]KeyValue, length)
+		goVal = kvs
+	}
+	st.visit(ptr, goVal)
+	for i:=0; i<length; i++ {
+		C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(i)) // [ ... map entries entry ]
+		C.duk_get_prop_index(ctx, -1, 0)                  // [ ... map entries entry key ]
+		k, e := st.fromJsValue(ctx)
+		C.duk_pop(ctx) // [ ... map entries entry ]
+		if e != nil {
+			C.duk_pop(ctx)
+			goVal, err = nil, e
+			return
+		}
+		C.duk_get_prop_index(ctx, -1, 1) // [ ... map entries entry value ]
+		v, e := st.fromJsValue(ctx)
+		C.duk_pop_2(ctx) // [ ... map entries ]
+		if e != nil {
+			goVal, err = nil, e
+			return
+		}
+		if strKeys {
+			res[k.(string)] = v
+		} else {
+			kvs[i] = KeyValue{Key: k, Value: v}
+		}
+	}
+	return
+}
+
+// fromSetObj converts a Set to []interface{}.
+func (st *fromJsState) fromSetObj(ctx *C.duk_context, ptr unsafe.Pointer) (goVal interface{}, err error) {
+	// [ ... set ]
+	C.duk_dup(ctx, -1) // [ ... set set ]
+	if err = callClassHelper(ctx, valuesName, 1); err != nil { // [ ... set values ]
+		C.duk_pop(ctx)
+		return
+	}
+	defer C.duk_pop(ctx) // [ ... set ]
+
+	length := int(C.duk_get_length(ctx, -1))
+	if err = st.addElements(length); err != nil {
+		return
+	}
+	res := make([]interface{}, length)
+	st.visit(ptr, res)
+	for i:=0; i<length; i++ {
+		C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(i)) // [ ... set values v ]
+		v, e := st.fromJsValue(ctx)
+		C.duk_pop(ctx) // [ ... set values ]
+		if e != nil {
+			err = e
+			return
+		}
+		res[i] = v
+	}
+	goVal = res
+	return
+}
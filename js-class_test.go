@@ -0,0 +1,75 @@
+package djs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// a minimal polyfill of Map and Set, Duktape has neither of them.
+const mapSetPolyfill = `
+function Map() { this._k = []; this._v = []; }
+Map.prototype.set = function(k, v) {
+	var i = this._k.indexOf(k);
+	if (i < 0) { this._k.push(k); this._v.push(v); } else { this._v[i] = v; }
+	return this;
+};
+Map.prototype.forEach = function(fn) {
+	for (var i = 0; i < this._k.length; i++) { fn(this._v[i], this._k[i], this); }
+};
+Map.prototype[Symbol.toStringTag] = 'Map';
+function Set() { this._v = []; }
+Set.prototype.add = function(v) {
+	if (this._v.indexOf(v) < 0) { this._v.push(v); }
+	return this;
+};
+Set.prototype.forEach = function(fn) {
+	for (var i = 0; i < this._v.length; i++) { fn(this._v[i], this._v[i], this); }
+};
+Set.prototype[Symbol.toStringTag] = 'Set';
+`
+
+func TestMapSetWithPolyfill(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Eval(mapSetPolyfill, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ctx.Eval("new Map().set('a', 1).set('b', 'x')", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"a": float64(1), "b": "x"}; !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %#v, want %#v", res, want)
+	}
+
+	if res, err = ctx.Eval("new Map().set(1, 'one').set('two', 2)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []KeyValue{{float64(1), "one"}, {"two", float64(2)}}; !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %#v, want %#v", res, want)
+	}
+
+	if res, err = ctx.Eval("new Set().add(1).add('a').add(1)", nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{float64(1), "a"}; !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %#v, want %#v", res, want)
+	}
+}
+
+func TestMapSetWithoutPolyfill(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ctx.Eval("typeof Map + ',' + typeof Set", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "undefined,undefined" {
+		t.Fatalf("got %v, Map and Set are builtins now", res)
+	}
+}
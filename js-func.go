@@ -1,12 +1,16 @@
 package djs
 
 // #include "duktape.h"
-// extern duk_ret_t freeJsFunc(duk_context *ctx);
+// static const char *getCString(duk_context *ctx, duk_idx_t idx);
 import "C"
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
+	"context"
+	"fmt"
 	"reflect"
-	"time"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
 )
 
 func bindFunc(ctx *JsContext, funcName string, funcVarPtr interface{}) (err error) {
@@ -21,8 +25,10 @@ func bindFunc(ctx *JsContext, funcName string, funcVarPtr interface{}) (err erro
 
 func wrapFunc(ctx *JsContext, funcName string, helper *elutils.EmbeddingFuncHelper) elutils.FnGoFunc {
 	return func(args []reflect.Value) (results []reflect.Value) {
-		ctx.mu.Lock()
-		defer ctx.mu.Unlock()
+		if err := ctx.lock(); err != nil {
+			return helper.ToGolangResults(nil, false, err)
+		}
+		defer ctx.unlock()
 
 		c := ctx.c
 		// reload the function when calling go-function
@@ -36,6 +42,8 @@ func wrapFunc(ctx *JsContext, funcName string, helper *elutils.EmbeddingFuncHelp
 // called by wrapFunc() and fromJsFunc::bindGoFunc()
 func callJsFuncFromGo(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, args []reflect.Value)  (results []reflect.Value) {
 	// [ some-obj function ]
+	enterCall(ctx)
+	defer leaveCall(ctx)
 
 	// push js args
 	argc := 0
@@ -45,9 +53,18 @@ func callJsFuncFromGo(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, a
 		argc += 1
 	}
 	// [ some-obj function arg1 arg2 ... argN ]
+	if err := takePushError(ctx); err != nil {
+		C.duk_pop_n(ctx, C.duk_idx_t(argc+2)) // [ ]
+		return helper.ToGolangResults(nil, false, err)
+	}
 
-	// call JS function
-	C.duk_call(ctx, C.int(argc)) // [ some-obj retval ]
+	// call JS function, an error thrown by it is returned to Go
+	if C.duk_pcall(ctx, C.duk_idx_t(argc)) != 0 { // [ some-obj error ]
+		err := fmt.Errorf("%s", C.GoString(C.getCString(ctx, -1)))
+		C.duk_pop_n(ctx, 2) // [ ]
+		return helper.ToGolangResults(nil, false, err)
+	}
+	// [ some-obj retval ]
 
 	// convert result to golang
 	goVal, err := fromJsValue(ctx)
@@ -56,71 +73,240 @@ func callJsFuncFromGo(ctx *C.duk_context, helper *elutils.EmbeddingFuncHelper, a
 	return
 }
 
-func callFunc(ctx *C.duk_context, args ...interface{}) {
+func callFunc(ctx *C.duk_context, args ...interface{}) (err error) {
 	// [ obj function ]
 	n := len(args)
 	for _, arg := range args {
 		pushJsProxyValue(ctx, arg)
 	}
 	// [ obj function arg1 arg2 ... argN ]
+	if err = takePushError(ctx); err != nil {
+		C.duk_pop_n(ctx, C.duk_idx_t(n)) // [ obj function ]
+		return
+	}
 
-	C.duk_call(ctx, C.int(n)) // [ obj retval ]
-}
-
-//export freeJsFunc
-func freeJsFunc(ctx *C.duk_context) C.duk_ret_t {
-	// [0] function
-	// [1] ...
-	if idx, isProxy := getTargetIdx(ctx); isProxy {
-		C.duk_push_global_stash(ctx)            // [ ... stash ]
-		C.duk_del_prop_index(ctx, -1, C.duk_uarridx_t(idx)) // [ ... stash ]
-		C.duk_pop(ctx)                          // [ ... ]
+	if C.duk_pcall(ctx, C.duk_idx_t(n)) != 0 { // [ obj error ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(ctx, -1)))
 	}
-	return 0
+	// [ obj retval/error ]
+	return
 }
 
-// called by value.go::fromJsValue
-func fromJsFunc(ctx *C.duk_context) (bindGoFunc elutils.FnBindGoFunc) {
-	// [ function ]
-	var name *C.char
-	var idx uint32
+var (
+	jsFuncType = reflect.TypeOf((*JsFunction)(nil))
+	jsFuncSeq atomic.Uint32 // ids of JS functions in the global stash, shared by all heaps
+)
 
-	getStrPtr(&idxName, &name)
-	if C.duk_get_prop_string(ctx, -1, name) != 0 {
-		// [ function idx ]
-		idx = uint32(C.duk_get_uint(ctx, -1))
-		C.duk_pop(ctx) // [ function ]
-	} else {
-		// [ funciton undefined ]
-		C.duk_pop(ctx) // [ function ]
+// newFuncId returns an id not used in the global stash.
+func newFuncId(ctx *C.duk_context) (id uint32) {
+	C.duk_push_global_stash(ctx) // [ ... stash ]
+	for {
+		// 0 is no id, 0xFFFFFFFF is not an array index
+		if id = jsFuncSeq.Add(1); id != 0 && id != 0xFFFFFFFF && C.duk_has_prop_index(ctx, -1, C.duk_uarridx_t(id)) == 0 {
+			break
+		}
+	}
+	C.duk_pop(ctx) // [ ... ]
+	return
+}
 
-		idx = uint32(time.Now().UnixNano()) // NOTE: make sure different functions with different idx-s. Maybe powerful CPU can product same idx-s.
-		C.duk_push_uint(ctx, C.duk_uint_t(idx)) // [ funciton idx ]
-		C.duk_put_prop_string(ctx, -2, name) // [ function ] with function[idxName] = idx
+// stashFunc stores the function on the top of the stack as stash[id].
+func stashFunc(ctx *C.duk_context, id uint32) {
+	// [ ... function ]
+	C.duk_push_global_stash(ctx) // [ ... function stash ]
+	C.duk_dup(ctx, -2)           // [ ... function stash function ]
+	C.duk_put_prop_index(ctx, -2, C.duk_uarridx_t(id)) // [ ... function stash ] with stash[id] = function
+	C.duk_pop(ctx) // [ ... function ]
+}
 
-		C.duk_push_c_function(ctx, (C.duk_c_function)(C.freeJsFunc), 2) // [ function finalizer ]
-		C.duk_set_finalizer(ctx, -2) // [ function ]
+// releaseFuncs removes the functions of ids from the stash, the context must be locked.
+func releaseFuncs(ctx *C.duk_context, ids []uint32) {
+	if len(ids) == 0 {
+		return
+	}
+	C.duk_push_global_stash(ctx) // [ stash ]
+	for _, id := range ids {
+		C.duk_del_prop_index(ctx, -1, C.duk_uarridx_t(id))
+	}
+	C.duk_pop(ctx) // [ ]
+}
 
-		C.duk_push_global_stash(ctx) // [ function stash ]
-		C.duk_dup(ctx, -2) // [ function stash function ]
-		C.duk_put_prop_index(ctx, -2, C.duk_uarridx_t(idx)) // [ function stash ] with stash[idx] = function
-		C.duk_pop(ctx) // [ function ]
+// called by value.go::fromJsValue
+func fromJsFunc(ctx *C.duk_context) (bindGoFunc elutils.FnBindGoFunc, err error) {
+	// [ function ]
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	if state.jsCtx == nil {
+		err = fmt.Errorf("function converted out of a call of the context")
+		return
 	}
+	// every converted function is stashed with its own id, which is released when
+	// the handle is garbage collected by Go.
+	id := newFuncId(ctx)
+	stashFunc(ctx, id) // [ function ]
+	f := newJsFunctionHandle(state.jsCtx, id)
 
 	bindGoFunc = func(fnVarPtr interface{}) elutils.FnGoFunc {
+		if fnPtr, ok := fnVarPtr.(**JsFunction); ok {
+			// called by decodeJsFunction()
+			*fnPtr = newJsFunction(f)
+			return nil
+		}
 		helper, e := elutils.NewEmbeddingFuncHelper(fnVarPtr)
 		if e != nil {
 			return nil
 		}
 
 		return func(args []reflect.Value) (results []reflect.Value) {
+			jsCtx := f.ctx
+			if err := jsCtx.lock(); err != nil {
+				return helper.ToGolangResults(nil, false, err)
+			}
+			defer jsCtx.unlock()
+
 			// reload the function when calling go-function
-			C.duk_push_global_stash(ctx) // [ stash ]
-			C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(idx)) // [ stash function ]
+			c := jsCtx.c
+			C.duk_push_global_stash(c) // [ stash ]
+			C.duk_get_prop_index(c, -1, C.duk_uarridx_t(f.id)) // [ stash function ]
 
-			return callJsFuncFromGo(ctx, helper, args)
+			return callJsFuncFromGo(c, helper, args)
 		}
 	}
 
-	return bindGoFunc
+	return
+}
+
+// JsFunction is a handle of a JS function, such as a callback passed by a script to
+// a Go function with an argument of type *JsFunction. The function is kept from being
+// garbage collected by JS until Release() is called or the handle is garbage collected
+// by Go, and the handle keeps the context from being garbage collected. It can be called
+// from any goroutine, calls are serialized by the lock of the context, which is reentrant
+// for a Go function called by JS.
+type JsFunction struct {
+	ctx *JsContext
+	id uint32 // index in the global stash, 0 if released
+}
+
+// newJsFunctionHandle makes a handle of stash[id], which is released when the handle is
+// garbage collected.
+func newJsFunctionHandle(ctx *JsContext, id uint32) (f *JsFunction) {
+	f = &JsFunction{ctx: ctx, id: id}
+	runtime.SetFinalizer(f, (*JsFunction).finalize)
+	return
+}
+
+// newJsFunction makes a handle of the function of from, which is stored in the stash
+// with a new id, so that it is released independently.
+func newJsFunction(from *JsFunction) (f *JsFunction) {
+	ctx := from.ctx
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	C.duk_push_global_stash(c) // [ stash ]
+	C.duk_get_prop_index(c, -1, C.duk_uarridx_t(from.id)) // [ stash function ]
+	id := newFuncId(c)
+	stashFunc(c, id)
+	C.duk_pop_2(c) // [ ]
+	return newJsFunctionHandle(ctx, id)
+}
+
+// Call calls the function with args, and returns the result like Eval().
+func (f *JsFunction) Call(args ...interface{}) (res interface{}, err error) {
+	return f.CallContext(context.Background(), args...)
+}
+
+// CallContext is Call() giving up if goCtx is done before the context is available.
+// A running function is not interrupted.
+func (f *JsFunction) CallContext(goCtx context.Context, args ...interface{}) (res interface{}, err error) {
+	ctx := f.ctx
+	if err = ctx.mu.lockContext(goCtx); err != nil {
+		return
+	}
+	defer ctx.mu.Unlock()
+	if err = goCtx.Err(); err != nil {
+		return
+	}
+	if err = ctx.enter(); err != nil {
+		err = fmt.Errorf("context of the function is freed")
+		return
+	}
+	defer ctx.leave()
+	if f.id == 0 {
+		err = fmt.Errorf("function is released")
+		return
+	}
+
+	c := ctx.c
+	enterCall(c)
+	defer leaveCall(c)
+	C.duk_push_global_stash(c) // [ stash ]
+	C.duk_get_prop_index(c, -1, C.duk_uarridx_t(f.id)) // [ stash function ]
+	for _, arg := range args {
+		pushJsProxyValue(c, arg)
+	}
+	// [ stash function arg1 ... argN ]
+	if err = takePushError(c); err != nil {
+		C.duk_pop_n(c, C.duk_idx_t(len(args)+2)) // [ ]
+		return
+	}
+	defer C.duk_pop_2(c) // [ ]
+	if C.duk_pcall(c, C.duk_idx_t(len(args))) != 0 { // [ stash retval/error ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(c, -1)))
+		return
+	}
+	return fromJsResult(c)
+}
+
+// Release lets the function be garbage collected by JS, the handle is not callable after it.
+func (f *JsFunction) Release() {
+	ctx := f.ctx
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if f.id == 0 || ctx.state.closed {
+		f.id = 0
+		return
+	}
+	releaseFuncs(ctx.c, []uint32{f.id})
+	f.id = 0
+}
+
+// finalize doesn't wait for the lock of the context, the function is removed
+// from the stash the next time the context is locked.
+func (f *JsFunction) finalize() {
+	// f is unreachable, so f.id is not changed by Release() any more.
+	if f.id != 0 {
+		f.ctx.state.addReleasedFunc(f.id)
+	}
+}
+
+func pushJsFunction(ctx *C.duk_context, f *JsFunction) {
+	if f.ctx.state != getCtxState(uintptr(unsafe.Pointer(ctx))) || f.id == 0 {
+		setPushError(ctx, fmt.Errorf("function is released or of another context"))
+		C.duk_push_undefined(ctx)
+		return
+	}
+	C.duk_push_global_stash(ctx) // [ stash ]
+	C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(f.id)) // [ stash function ]
+	C.duk_remove(ctx, -2) // [ function ]
+}
+
+// decodeJsFunction stores a function converted by fromJsFunc() in dest of type *JsFunction.
+func decodeJsFunction(dest reflect.Value, val interface{}) (err error) {
+	switch v := val.(type) {
+	case *JsFunction:
+		dest.Set(reflect.ValueOf(v))
+	case elutils.FnBindGoFunc:
+		var f *JsFunction
+		v(&f)
+		if f == nil {
+			err = fmt.Errorf("context of the function is freed")
+			return
+		}
+		dest.Set(reflect.ValueOf(f))
+	default:
+		err = fmt.Errorf("cannot convert %T to *JsFunction", val)
+	}
+	return
 }
@@ -0,0 +1,40 @@
+package djs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallThrowingFunc(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Eval("function f(x) { if (x) { throw new Error('boom') } return 1 }", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ctx.CallFunc("f", true); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got error %v, want boom", err)
+	}
+	err = ctx.CallFuncBorrow("f", func(res interface{}) error { return nil }, true)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got error %v, want boom", err)
+	}
+
+	var f func(bool) (int, error)
+	if err = ctx.BindFunc("f", &f); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f(true); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got error %v, want boom", err)
+	}
+
+	// the stack is balanced after the errors
+	if res, err := ctx.CallFunc("f", false); err != nil || res != float64(1) {
+		t.Fatalf("got %v %v, want 1", res, err)
+	}
+	if n, err := f(false); err != nil || n != 1 {
+		t.Fatalf("got %v %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,114 @@
+package djs
+
+// #include "duktape.h"
+// static const char *getCString(duk_context *ctx, duk_idx_t idx);
+import "C"
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// toMapKey converts a JS property name to a value of the map key type.
+func toMapKey(keyType reflect.Type, key string) (kv reflect.Value, err error) {
+	if reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+		p := reflect.New(keyType)
+		if err = p.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+			return
+		}
+		kv = p.Elem()
+		return
+	}
+
+	kv = reflect.New(keyType).Elem()
+	switch keyType.Kind() {
+	case reflect.String:
+		kv.SetString(key)
+	case reflect.Interface:
+		if !reflect.TypeOf(key).AssignableTo(keyType) {
+			err = fmt.Errorf("cannot use key %q as %v", key, keyType)
+			return
+		}
+		kv.Set(reflect.ValueOf(key))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, e := strconv.ParseInt(key, 10, 64)
+		if e != nil || kv.OverflowInt(i) {
+			err = fmt.Errorf("cannot use key %q as %v", key, keyType)
+			return
+		}
+		kv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, e := strconv.ParseUint(key, 10, 64)
+		if e != nil || kv.OverflowUint(u) {
+			err = fmt.Errorf("cannot use key %q as %v", key, keyType)
+			return
+		}
+		kv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(key, 64)
+		if e != nil || kv.OverflowFloat(f) {
+			err = fmt.Errorf("cannot use key %q as %v", key, keyType)
+			return
+		}
+		kv.SetFloat(f)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(key)
+		if e != nil {
+			err = fmt.Errorf("cannot use key %q as %v", key, keyType)
+			return
+		}
+		kv.SetBool(b)
+	default:
+		err = fmt.Errorf("unsupported map key type %v", keyType)
+	}
+	return
+}
+
+// mapKeyString renders a map key as a JS property name.
+func mapKeyString(k reflect.Value) string {
+	if k.Kind() == reflect.Interface && !k.IsNil() {
+		k = k.Elem()
+	}
+	if k.CanInterface() {
+		if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+			if b, err := m.MarshalText(); err == nil {
+				return string(b)
+			}
+		}
+	}
+	switch k.Kind() {
+	case reflect.String:
+		return k.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(k.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(k.Float(), 'g', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool())
+	}
+	if k.CanInterface() {
+		return fmt.Sprintf("%v", k.Interface())
+	}
+	return ""
+}
+
+// getMapKey converts the property key at keyIdx to a value of the key type of the map vv.
+// symbols are not map keys.
+func getMapKey(ctx *C.duk_context, vv reflect.Value, keyIdx C.duk_idx_t) (kv reflect.Value, ok bool) {
+	if C.duk_is_symbol(ctx, keyIdx) != 0 {
+		return
+	}
+	if C.duk_is_string(ctx, keyIdx) == 0 && C.duk_is_number(ctx, keyIdx) == 0 {
+		return
+	}
+	key := C.GoString(C.getCString(ctx, keyIdx))
+	kv, err := toMapKey(vv.Type().Key(), key)
+	return kv, err == nil
+}
@@ -3,8 +3,10 @@ package djs
 // #include "duktape.h"
 // extern duk_ret_t modSearch(duk_context *ctx);
 // static const char *getCString(duk_context *ctx, duk_idx_t idx);
+// static void clearProp(duk_context *ctx, duk_idx_t objIdx);
 import "C"
 import (
+	"unsafe"
 	"strings"
 	"fmt"
 	"os"
@@ -19,16 +21,22 @@ func modSearch(ctx *C.duk_context) C.duk_ret_t {
 	 *   index 2: exports
 	 *   index 3: module
 	 */
-	modPath := C.GoString(C.getCString(ctx, 0))
+	modID := C.GoString(C.getCString(ctx, 0))
+	modPath := modID
 	if !strings.HasSuffix(modPath, ".js") {
 		modPath = fmt.Sprintf("%s.js", modPath)
 	}
 
-	absModPath := toAbsPath(exePath, modPath)
+	state := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	absModPath, err := state.resolveModule(modID, modPath)
+	if err != nil {
+		return C.DUK_RET_ERROR
+	}
 	b, err := os.ReadFile(absModPath)
 	if err != nil {
 		return 0
 	}
+	state.addModFile(absModPath, modID, b)
 
 	var src *C.char
 	var size C.int
@@ -60,6 +68,33 @@ func setModSearch(ctx *C.duk_context) {
 	C.duk_pop(ctx)
 }
 
+// forgetModules removes the modules cached in Duktape.modLoaded, so they will be
+// loaded again by require(). It matters for contexts sharing the global heap.
+func (ctx *JsContext) forgetModules(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	duktape, modLoaded := "Duktape", "modLoaded"
+	var cName *C.char
+	var length C.int
+	getStrPtrLen(&duktape, &cName, &length)
+	C.duk_get_global_lstring(c, cName, C.size_t(length)) // [ Duktape ]
+	getStrPtrLen(&modLoaded, &cName, &length)
+	if C.duk_get_prop_lstring(c, -1, cName, C.size_t(length)) != 0 { // [ Duktape modLoaded ]
+		for _, id := range ids {
+			pushString(c, id)   // [ Duktape modLoaded id ]
+			C.clearProp(c, -2) // [ Duktape modLoaded ]
+		}
+	}
+	C.duk_pop_n(c, 2) // [ ]
+}
+
 func getExecWD() (string, error) {
 	exePath, err := os.Executable()
 	if err != nil {
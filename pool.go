@@ -0,0 +1,290 @@
+package djs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FnSetupContext is called once for every context created by a Pool,
+// it is the place to load script files and register globals.
+type FnSetupContext func(ctx *JsContext) error
+
+type PoolConfig struct {
+	Size        int           // number of contexts created by NewPool
+	MaxSize     int           // max number of contexts, 0 means Size
+	IdleTimeout time.Duration // idle contexts exceeding Size are evicted after IdleTimeout, 0 means never
+	Setup       FnSetupContext
+}
+
+type PoolStats struct {
+	Total     int           // contexts created and not evicted
+	Idle      int           // contexts waiting in the pool
+	Gets      uint64        // times of Get() succeeded
+	Waits     uint64        // times of Get() having to wait for a context
+	Timeouts  uint64        // times of Get() given up because of the go context
+	Evicted   uint64        // contexts evicted for being idle too long
+	TotalWait time.Duration // total time spent waiting in Get()
+	MaxWait   time.Duration // longest time spent waiting in Get()
+}
+
+type pooledContext struct {
+	ctx       *JsContext
+	idleSince time.Time
+}
+
+type Pool struct {
+	conf  PoolConfig
+	idle  chan *pooledContext
+	inUse map[*JsContext]*pooledContext
+
+	mu     *sync.Mutex
+	total  int
+	stats  PoolStats
+	closed bool
+	done   chan struct{}
+}
+
+// NewPool pre-creates conf.Size contexts, every one is isolated from the
+// others and initialized by conf.Setup.
+func NewPool(conf PoolConfig) (p *Pool, err error) {
+	if conf.Size <= 0 {
+		err = fmt.Errorf("pool size must be greater than 0")
+		return
+	}
+	if conf.MaxSize < conf.Size {
+		conf.MaxSize = conf.Size
+	}
+
+	p = &Pool{
+		conf: conf,
+		idle: make(chan *pooledContext, conf.MaxSize),
+		inUse: make(map[*JsContext]*pooledContext),
+		mu: &sync.Mutex{},
+		done: make(chan struct{}),
+	}
+	for i:=0; i<conf.Size; i++ {
+		pc, e := p.newContext()
+		if e != nil {
+			err = e
+			p = nil
+			return
+		}
+		p.total += 1
+		p.idle <- pc
+	}
+
+	if conf.IdleTimeout > 0 {
+		go p.evictIdle()
+	}
+	return
+}
+
+func (p *Pool) newContext() (pc *pooledContext, err error) {
+	ctx, e := NewContext(true)
+	if e != nil {
+		err = e
+		return
+	}
+	if p.conf.Setup != nil {
+		err = p.conf.Setup(ctx)
+	}
+	if err == nil {
+		err = ctx.Snapshot()
+	}
+	if err != nil {
+		ctx.free()
+		return
+	}
+	pc = &pooledContext{
+		ctx: ctx,
+	}
+	return
+}
+
+// Get returns an idle context, or creates a new one if the pool is not
+// full, or waits until a context is put back or goCtx is done.
+func (p *Pool) Get(goCtx context.Context) (ctx *JsContext, err error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		err = fmt.Errorf("pool closed")
+		return
+	}
+
+	select {
+	case pc := <-p.idle:
+		return p.acquire(pc, 0)
+	default:
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		err = fmt.Errorf("pool closed")
+		return
+	}
+	if p.total < p.conf.MaxSize {
+		p.total += 1
+		p.mu.Unlock()
+
+		pc, e := p.newContext()
+		if e != nil {
+			p.mu.Lock()
+			p.total -= 1
+			p.mu.Unlock()
+			err = e
+			return
+		}
+		return p.acquire(pc, 0)
+	}
+	p.mu.Unlock()
+
+	start := time.Now()
+	select {
+	case pc := <-p.idle:
+		return p.acquire(pc, time.Since(start))
+	case <-goCtx.Done():
+		p.mu.Lock()
+		p.stats.Waits += 1
+		p.stats.Timeouts += 1
+		p.addWait(time.Since(start))
+		p.mu.Unlock()
+		err = goCtx.Err()
+		return
+	case <-p.done:
+		err = fmt.Errorf("pool closed")
+		return
+	}
+}
+
+// acquire marks pc in use, or frees it if the pool is closed after pc is taken.
+func (p *Pool) acquire(pc *pooledContext, waited time.Duration) (ctx *JsContext, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		p.total -= 1
+		pc.ctx.free()
+		err = fmt.Errorf("pool closed")
+		return
+	}
+	p.stats.Gets += 1
+	if waited > 0 {
+		p.stats.Waits += 1
+		p.addWait(waited)
+	}
+	p.inUse[pc.ctx] = pc
+	return pc.ctx, nil
+}
+
+func (p *Pool) addWait(waited time.Duration) {
+	p.stats.TotalWait += waited
+	if waited > p.stats.MaxWait {
+		p.stats.MaxWait = waited
+	}
+}
+
+// Put gives back a context got from Get. The context is reset to the state
+// after Setup before it is reused, see JsContext.Reset(), a context failed to
+// be reset is discarded.
+func (p *Pool) Put(ctx *JsContext) {
+	p.mu.Lock()
+	pc, ok := p.inUse[ctx]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.inUse, ctx)
+	closed := p.closed
+	p.mu.Unlock()
+
+	var err error
+	if !closed {
+		err = ctx.Reset()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || err != nil {
+		p.total -= 1
+		ctx.free()
+		return
+	}
+	pc.idleSince = time.Now()
+	p.idle <- pc // never blocks, the capacity is MaxSize
+}
+
+// Stats returns a snapshot of the pool metrics.
+func (p *Pool) Stats() (stats PoolStats) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats = p.stats
+	stats.Total = p.total
+	stats.Idle = len(p.idle)
+	return
+}
+
+// Close frees all idle contexts, contexts in use are freed when put back.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.mu.Unlock()
+
+	for {
+		select {
+		case pc := <-p.idle:
+			p.mu.Lock()
+			p.total -= 1
+			p.mu.Unlock()
+			pc.ctx.free()
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) evictIdle() {
+	ticker := time.NewTicker(p.conf.IdleTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		for n := len(p.idle); n > 0; n-- {
+			var pc *pooledContext
+			select {
+			case pc = <-p.idle:
+			default:
+			}
+			if pc == nil {
+				break
+			}
+
+			p.mu.Lock()
+			if p.closed {
+				p.total -= 1
+				pc.ctx.free()
+			} else if p.total > p.conf.Size && time.Since(pc.idleSince) >= p.conf.IdleTimeout {
+				p.total -= 1
+				p.stats.Evicted += 1
+				pc.ctx.free()
+			} else {
+				p.idle <- pc
+			}
+			p.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package djs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Sandbox restricts the globals and builtins a script can use.
+type Sandbox struct {
+	AllowGlobals   []string // if not empty, globals not in the list are removed. StandardGlobals can be used as a base
+	DenyGlobals    []string // globals to be removed
+	DisableEval    bool     // remove eval() and disable the Function constructor
+	RemoveDuktape  bool     // remove the global object Duktape
+	AllowedModules []string // ids of modules can be loaded by require(), require() is removed if it is empty
+	ModuleRoot     string   // directory to search modules, default is the directory of the executable
+}
+
+// StandardGlobals are the globals defined by ECMAScript.
+var StandardGlobals = []string{
+	"NaN", "Infinity", "undefined", "globalThis",
+	"parseInt", "parseFloat", "isNaN", "isFinite",
+	"decodeURI", "decodeURIComponent", "encodeURI", "encodeURIComponent", "escape", "unescape",
+	"Object", "Function", "Array", "String", "Boolean", "Number", "Date", "RegExp", "Math", "JSON", "Symbol", "Reflect", "Proxy", "Promise",
+	"Error", "EvalError", "RangeError", "ReferenceError", "SyntaxError", "TypeError", "URIError",
+	"ArrayBuffer", "DataView", "Int8Array", "Uint8Array", "Uint8ClampedArray", "Int16Array", "Uint16Array",
+	"Int32Array", "Uint32Array", "Float32Array", "Float64Array",
+	"TextEncoder", "TextDecoder",
+}
+
+const disableEvalScript = `(function(g) {
+	var disabled = function() {
+		throw new TypeError('Function constructor is disabled');
+	};
+	disabled.prototype = Function.prototype;
+	Object.defineProperty(Function.prototype, 'constructor', {
+		value: disabled, writable: false, enumerable: false, configurable: false
+	});
+	g.Function = disabled;
+	delete g.eval;
+})(this);`
+
+func (ctx *JsContext) applySandbox(sandbox *Sandbox) (err error) {
+	if sandbox.DisableEval {
+		if _, err = ctx.Eval(disableEvalScript, nil); err != nil {
+			return
+		}
+	}
+
+	removed := map[string]bool{}
+	for _, name := range sandbox.DenyGlobals {
+		removed[name] = true
+	}
+	if sandbox.DisableEval {
+		removed["eval"] = true
+	}
+	if sandbox.RemoveDuktape {
+		removed["Duktape"] = true // require() still works with the Duktape object stashed
+	}
+	if len(sandbox.AllowedModules) == 0 {
+		removed["require"] = true
+	}
+	if len(sandbox.AllowGlobals) > 0 {
+		allowed := map[string]bool{}
+		for _, name := range sandbox.AllowGlobals {
+			allowed[name] = true
+		}
+		if len(sandbox.AllowedModules) > 0 {
+			allowed["require"] = true
+		}
+		for name, _ := range ctx.globalNames() {
+			if !allowed[name] {
+				removed[name] = true
+			}
+		}
+	}
+
+	keep := ctx.globalNames()
+	for name, _ := range removed {
+		delete(keep, name)
+	}
+	ctx.deleteGlobalsExcept(keep)
+	return
+}
+
+// called by modSearch() to check whether the module can be loaded in a sandbox, and to find the module file.
+func (s *ctxState) resolveModule(modID string, modPath string) (absModPath string, err error) {
+	sandbox := s.options.Sandbox
+	if sandbox == nil {
+		absModPath = toAbsPath(exePath, modPath)
+		return
+	}
+
+	allowed := false
+	for _, id := range sandbox.AllowedModules {
+		if id == modID || id == modPath {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		err = fmt.Errorf("module %s is not allowed", modID)
+		return
+	}
+
+	root := sandbox.ModuleRoot
+	if len(root) == 0 {
+		root = exePath
+	}
+	absModPath = path.Join(root, path.Clean("/"+modPath))
+	if !strings.HasPrefix(absModPath, path.Clean(root)+"/") {
+		err = fmt.Errorf("module %s is out of the module root", modID)
+	}
+	return
+}
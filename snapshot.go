@@ -0,0 +1,95 @@
+package djs
+
+// #include "duktape.h"
+// static const char *getCString(duk_context *ctx, duk_idx_t idx);
+import "C"
+import (
+	"fmt"
+)
+
+var resetFuncName = "\xFFreset\x00"
+
+// the builtins used by reset() are captured by the snapshot, so scripts altering
+// or removing them can't break it.
+const snapshotScript = `(function(hiddenName) {
+	var g = this, O = Object, D = g.Duktape;
+	var getNames = O.getOwnPropertyNames, getDesc = O.getOwnPropertyDescriptor, defProp = O.defineProperty;
+	var hasOwn = O.prototype.hasOwnProperty, keys = O.keys;
+	var descs = O.create(null), names = getNames(g), i, name;
+	for (i = 0; i < names.length; i++) {
+		descs[names[i]] = getDesc(g, names[i]);
+	}
+	var modLoaded = D && D.modLoaded, mods = O.create(null);
+	if (modLoaded) {
+		names = keys(modLoaded);
+		for (i = 0; i < names.length; i++) {
+			mods[names[i]] = modLoaded[names[i]];
+		}
+	}
+
+	defProp(g, hiddenName, {configurable: true, value: function() {
+		var names = getNames(g), i, name, d, c;
+		for (i = 0; i < names.length; i++) {
+			name = names[i];
+			if (hasOwn.call(descs, name)) {
+				continue;
+			}
+			try { delete g[name]; } catch (e) {}
+			if (hasOwn.call(g, name)) {
+				try { g[name] = undefined; } catch (e) {}
+			}
+		}
+		for (name in descs) {
+			d = descs[name];
+			c = getDesc(g, name);
+			if (c && c.value === d.value && c.get === d.get && c.set === d.set) {
+				continue;
+			}
+			try { defProp(g, name, d); } catch (e) {}
+		}
+		if (modLoaded) {
+			D.modLoaded = modLoaded;
+			names = keys(modLoaded);
+			for (i = 0; i < names.length; i++) {
+				if (!hasOwn.call(mods, names[i])) {
+					delete modLoaded[names[i]];
+				}
+			}
+		}
+	}});
+})`
+
+// Snapshot records the globals of the context and the modules loaded by require(),
+// to be restored by Reset(). It is usually called after the context is initialized.
+func (ctx *JsContext) Snapshot() (err error) {
+	_, err = ctx.callScriptFunc(snapshotScript, nil, resetFuncName[:len(resetFuncName)-1])
+	return
+}
+
+// Reset restores the context to the last Snapshot(): globals added after it are
+// deleted (or set to undefined if they can't be deleted), globals replaced after
+// it are restored, and modules loaded after it are removed from Duktape.modLoaded.
+// Changes made to the objects themselves, such as setting a property of a global
+// object, are not undone. The globals of contexts sharing a heap with shared
+// globals are reset for all of them.
+func (ctx *JsContext) Reset() (err error) {
+	if err = ctx.lock(); err != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	var name *C.char
+	getStrPtr(&resetFuncName, &name)
+	if C.duk_get_global_string(c, name) == 0 { // [ reset ]
+		C.duk_pop(c)
+		err = fmt.Errorf("no snapshot of the context")
+		return
+	}
+	C.duk_push_global_object(c) // [ reset global ]
+	if C.duk_pcall_method(c, 0) != 0 { // [ undefined/error ]
+		err = fmt.Errorf("%s", C.GoString(C.getCString(c, -1)))
+	}
+	C.duk_pop(c) // [ ]
+	return
+}
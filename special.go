@@ -0,0 +1,66 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"reflect"
+	"unsafe"
+)
+
+type specialValue struct {
+	name string
+}
+
+func (v specialValue) String() string {
+	return v.name
+}
+
+var (
+	// Undefined is pushed as undefined, and undefined is converted to it in strict conversion mode.
+	Undefined = specialValue{"undefined"}
+	// Null is pushed as null, and null is converted to it in strict conversion mode.
+	Null = specialValue{"null"}
+
+	specialType = reflect.TypeOf(Undefined)
+)
+
+func pushSpecialValue(ctx *C.duk_context, v specialValue) {
+	if v == Null {
+		C.duk_push_null(ctx)
+		return
+	}
+	C.duk_push_undefined(ctx)
+}
+
+// fromJsResult converts the result of a script or a function on the top of the stack,
+// undefined and null are converted to Undefined and Null if Options.StrictConversion is set.
+func fromJsResult(ctx *C.duk_context) (goVal interface{}, err error) {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	if !s.options.StrictConversion {
+		return fromJsValue(ctx)
+	}
+	s.setStrict(true)
+	defer s.setStrict(false)
+	return fromJsValue(ctx)
+}
+
+func (s *ctxState) setStrict(strict bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.strict = strict
+}
+
+func isStrictConverting(ctx *C.duk_context) bool {
+	s := getCtxState(uintptr(unsafe.Pointer(ctx)))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.strict
+}
+
+// plainNil converts Undefined and Null to nil unless they are stored in an interface.
+func plainNil(t reflect.Type, val interface{}) interface{} {
+	if _, ok := val.(specialValue); ok && t.Kind() != reflect.Interface {
+		return nil
+	}
+	return val
+}
@@ -0,0 +1,57 @@
+package djs
+
+import (
+	"testing"
+)
+
+func TestGetGlobalStrictConversion(t *testing.T) {
+	ctx, err := NewContextWithOptions(&Options{WithoutGlobalHeap: true, StrictConversion: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Eval("var u = undefined, n = null, o = {a: undefined, b: null}", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]interface{}{"u": Undefined, "n": Null} {
+		res, err := ctx.GetGlobal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != want {
+			t.Fatalf("%s: got %#v, want %#v", name, res, want)
+		}
+	}
+
+	res, err := ctx.GetGlobal("o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, ok := res.(map[string]interface{})
+	if !ok || o["a"] != Undefined || o["b"] != Null {
+		t.Fatalf("got %#v", res)
+	}
+
+	if _, err = ctx.GetGlobal("missing"); err == nil {
+		t.Fatal("missing global is found")
+	}
+}
+
+func TestGetGlobalLooseConversion(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ctx.Eval("var u = undefined, n = null", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"u", "n"} {
+		res, err := ctx.GetGlobal(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != nil {
+			t.Fatalf("%s: got %#v, want nil", name, res)
+		}
+	}
+}
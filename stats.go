@@ -0,0 +1,84 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Stats is the resource usage of a context. The heap, the stash and the Go values
+// are shared by all contexts of a Heap.
+type Stats struct {
+	HeapBytes   int // bytes allocated by the heap
+	HeapBlocks  int // memory blocks allocated by the heap, an object may take more than one block, so it is not a count of objects
+	StashSize   int // values kept in the global stash of the heap
+	GoValues    int // Go values referred by JS proxies, a value referred by many proxies is counted once
+	JsFunctions int // JS functions kept in the stash for Go, such as JsFunction-s and bound Go funcs
+	PinSites    map[string]int // number of Go values by the site they are pushed from, if Options.DebugPins is set
+}
+
+// Stats returns the resource usage of the context, it is zero if the context is freed
+// or its heap is closed.
+func (ctx *JsContext) Stats() (stats Stats) {
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	c := ctx.c
+	stats.HeapBytes, stats.HeapBlocks = ctx.mem.usage()
+	stats.GoValues, stats.PinSites = ptrStoreOf(c).stats()
+
+	C.duk_push_global_stash(c) // [ stash ]
+	C.duk_enum(c, -1, C.DUK_ENUM_OWN_PROPERTIES_ONLY|C.DUK_ENUM_INCLUDE_NONENUMERABLE|C.DUK_ENUM_INCLUDE_HIDDEN) // [ stash enum ]
+	for C.duk_next(c, -1, 0) != 0 {
+		// [ stash enum key ]
+		stats.StashSize += 1
+		if C.duk_is_string(c, -1) != 0 {
+			// functions are stored with index keys, see newFuncId()
+			if _, err := strconv.ParseUint(C.GoString(C.duk_get_string(c, -1)), 10, 32); err == nil {
+				stats.JsFunctions += 1
+			}
+		}
+		C.duk_pop(c) // [ stash enum ]
+	}
+	C.duk_pop_2(c) // [ ]
+	return
+}
+
+// GC runs a full garbage collection of the heap of the context, which also frees
+// the Go values no longer referred by JS.
+func (ctx *JsContext) GC() {
+	if ctx.lock() != nil {
+		return
+	}
+	defer ctx.unlock()
+
+	// the second pass frees the objects finalized by the first one.
+	C.duk_gc(ctx.c, 0)
+	C.duk_gc(ctx.c, 0)
+}
+
+var pkgPrefix = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(newPtrStore).Pointer()).Name()
+	return name[:strings.LastIndex(name, ".")+1]
+}()
+
+// pushSite returns the type of v and the caller out of the package pushing it.
+func pushSite(v interface{}) string {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, pkgPrefix) && !strings.HasPrefix(f.Function, "runtime.") && !strings.HasPrefix(f.Function, "_cgo") {
+			return fmt.Sprintf("%T at %s (%s:%d)", v, f.Function, f.File, f.Line)
+		}
+		if !more {
+			return fmt.Sprintf("%T", v)
+		}
+	}
+}
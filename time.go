@@ -0,0 +1,119 @@
+package djs
+
+// #include "duktape.h"
+import "C"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	dateClassName = "\xFFDate\x00"
+	globalDate    = "Date\x00"
+)
+
+// keep the Date constructor in a hidden global, it is still usable after Date is removed by a sandbox.
+func registerDateClass(ctx *C.duk_context) {
+	var name *C.char
+	getStrPtr(&globalDate, &name)
+	C.duk_get_global_string(ctx, name) // [ Date ]
+	getStrPtr(&dateClassName, &name)
+	C.duk_put_global_string(ctx, name) // [ ] with global[dateClassName] = Date
+}
+
+// pushTime pushes t as a Date object with millisecond precision.
+func pushTime(ctx *C.duk_context, t time.Time) {
+	var name *C.char
+	getStrPtr(&dateClassName, &name)
+	C.duk_get_global_string(ctx, name) // [ Date ]
+	C.duk_push_number(ctx, C.duk_double_t(t.UnixMilli())) // [ Date ms ]
+	C.duk_new(ctx, 1) // [ date ]
+}
+
+// pushDuration pushes d as number of milliseconds.
+func pushDuration(ctx *C.duk_context, d time.Duration) {
+	C.duk_push_number(ctx, C.duk_double_t(float64(d)/float64(time.Millisecond)))
+}
+
+// fromDateObj converts a Date object to time.Time in the location of Options.TimeLocation.
+func fromDateObj(ctx *C.duk_context) (goVal interface{}, isDate bool) {
+	// [ ... obj ]
+	var name *C.char
+	getStrPtr(&dateClassName, &name)
+	if C.duk_get_global_string(ctx, name) == 0 { // [ ... obj Date ]
+		C.duk_pop(ctx) // [ ... obj ]
+		return
+	}
+	isDate = C.duk_instanceof(ctx, -2, -1) != 0
+	C.duk_pop(ctx) // [ ... obj ]
+	if !isDate {
+		return
+	}
+
+	C.duk_dup(ctx, -1) // [ ... obj obj ]
+	ms := float64(C.duk_to_number(ctx, -1)) // [ ... obj ms ] valueOf() of Date is getTime()
+	C.duk_pop(ctx) // [ ... obj ]
+	if math.IsNaN(ms) {
+		goVal = time.Time{} // Invalid Date
+		return
+	}
+	goVal = time.UnixMilli(int64(ms)).In(getTimeLocation(ctx))
+	return
+}
+
+func getTimeLocation(ctx *C.duk_context) *time.Location {
+	if loc := getCtxState(uintptr(unsafe.Pointer(ctx))).options.TimeLocation; loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// decodeTime converts a time.Time, a number of milliseconds since epoch or a RFC3339 string to time.Time.
+func decodeTime(dest reflect.Value, val interface{}) (err error) {
+	var t time.Time
+	switch v := val.(type) {
+	case time.Time:
+		t = v
+	case float64:
+		t = time.UnixMilli(int64(v))
+	case int64:
+		t = time.UnixMilli(v)
+	case string:
+		if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("cannot convert %T to time.Time", val)
+		return
+	}
+	dest.Set(reflect.ValueOf(t).Convert(dest.Type()))
+	return
+}
+
+// decodeDuration converts a number of milliseconds or a duration string like "1h10s" to time.Duration.
+func decodeDuration(dest reflect.Value, val interface{}) (err error) {
+	var d time.Duration
+	switch v := val.(type) {
+	case time.Duration:
+		d = v
+	case float64:
+		d = time.Duration(v * float64(time.Millisecond))
+	case int64:
+		d = time.Duration(v) * time.Millisecond
+	case string:
+		if d, err = time.ParseDuration(v); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("cannot convert %T to time.Duration", val)
+		return
+	}
+	dest.SetInt(int64(d))
+	return
+}
@@ -0,0 +1,52 @@
+package djs
+
+import (
+	"testing"
+	"time"
+)
+
+type timeFields struct {
+	D time.Duration
+	T time.Time
+}
+
+func TestSetTimeFieldsFromJs(t *testing.T) {
+	ctx, err := NewContext(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &timeFields{D: 2 * time.Second}
+	env := map[string]interface{}{"s": s}
+
+	res, err := ctx.Eval("s.d", env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != float64(2000) {
+		t.Fatalf("got %v, want 2000", res)
+	}
+
+	if _, err = ctx.Eval("s.d = 1500; s.t = new Date(86400000)", env); err != nil {
+		t.Fatal(err)
+	}
+	if s.D != 1500*time.Millisecond {
+		t.Fatalf("got %v, want 1.5s", s.D)
+	}
+	if !s.T.Equal(time.UnixMilli(86400000)) {
+		t.Fatalf("got %v, want %v", s.T, time.UnixMilli(86400000))
+	}
+
+	if res, err = ctx.Eval("s.d", env); err != nil {
+		t.Fatal(err)
+	}
+	if res != float64(1500) {
+		t.Fatalf("got %v, want 1500", res)
+	}
+
+	if _, err = ctx.Eval("s.d = '1m'", env); err != nil {
+		t.Fatal(err)
+	}
+	if s.D != time.Minute {
+		t.Fatalf("got %v, want 1m", s.D)
+	}
+}
@@ -2,6 +2,52 @@ package djs
 
 // #include "duktape.h"
 // static const char *getCString(duk_context *ctx, duk_idx_t idx);
+// static duk_ret_t unsafeGetProp(duk_context *ctx, void *udata) {
+// 	duk_get_prop(ctx, -2); // [ ... obj key ] -> [ ... obj value ], the stack is not a new frame
+// 	return 1;
+// }
+// static duk_int_t safeGetProp(duk_context *ctx, duk_idx_t objIdx) {
+// 	objIdx = duk_normalize_index(ctx, objIdx);
+// 	duk_dup(ctx, objIdx);  // [ ... key obj ]
+// 	duk_swap_top(ctx, -2); // [ ... obj key ]
+// 	return duk_safe_call(ctx, unsafeGetProp, NULL, 2, 1); // [ ... value/error ]
+// }
+// static duk_bool_t isAccessor(duk_context *ctx, duk_idx_t objIdx) {
+// 	duk_bool_t accessor = 0;
+// 	objIdx = duk_normalize_index(ctx, objIdx);
+// 	duk_dup(ctx, objIdx); // [ ... key o ]
+// 	while (duk_is_object(ctx, -1)) {
+// 		duk_dup(ctx, -2);              // [ ... key o key ]
+// 		duk_get_prop_desc(ctx, -2, 0); // [ ... key o desc ]
+// 		if (duk_is_object(ctx, -1)) {
+// 			accessor = duk_has_prop_string(ctx, -1, "get") || duk_has_prop_string(ctx, -1, "set");
+// 			duk_pop(ctx);
+// 			break;
+// 		}
+// 		duk_pop(ctx);               // [ ... key o ]
+// 		duk_get_prototype(ctx, -1); // [ ... key o proto ]
+// 		duk_remove(ctx, -2);        // [ ... key proto ]
+// 	}
+// 	duk_pop(ctx); // [ ... key ]
+// 	return accessor;
+// }
+// static void pushSymbolString(duk_context *ctx, duk_idx_t idx) {
+// 	// [ ... ] -> [ ... "Symbol(description)" ], rendered like Symbol.prototype.toString()
+// 	// without calling JS. the internal string of a symbol is a prefix byte, the description,
+// 	// and an optional trailer starting with 0xFF.
+// 	duk_size_t len, i;
+// 	const char *p = duk_get_lstring(ctx, idx, &len);
+// 	if (p == NULL || len == 0) {
+// 		duk_push_string(ctx, "Symbol()");
+// 		return;
+// 	}
+// 	for (i = 1; i < len && (unsigned char)p[i] != 0xFF; i++) {
+// 	}
+// 	duk_push_string(ctx, "Symbol(");
+// 	duk_push_lstring(ctx, p+1, i-1);
+// 	duk_push_string(ctx, ")");
+// 	duk_concat(ctx, 3);
+// }
 import "C"
 import (
 	"unsafe"
@@ -9,11 +55,56 @@ import (
 	"math"
 )
 
+// CycleMode decides how a JS object referring to itself is converted to Go.
+type CycleMode int
+const (
+	CycleAsError     CycleMode = iota // converting fails with an error
+	CycleAsReference                  // the reference is converted to the Go map or slice being converted
+)
+
+const defaultMaxDepth = 1000
+
+// fromJsState tracks the objects being converted by fromJsValue().
+type fromJsState struct {
+	maxDepth      int
+	maxElements   int
+	cycleMode     CycleMode
+	ignoreGetters bool
+	symbolKeys    bool
+
+	depth    int
+	elements int
+	visiting map[unsafe.Pointer]interface{} // heap pointer of an object -> the Go value converted from it
+}
+
 func fromJsValue(ctx *C.duk_context) (goVal interface{}, err error) {
+	options := &getCtxState(uintptr(unsafe.Pointer(ctx))).options
+	st := &fromJsState{
+		maxDepth: options.MaxDepth,
+		maxElements: options.MaxElements,
+		cycleMode: options.CycleMode,
+		ignoreGetters: options.IgnoreGetters,
+		symbolKeys: options.SymbolKeys,
+	}
+	if st.maxDepth <= 0 {
+		st.maxDepth = defaultMaxDepth
+	}
+	return st.fromJsValue(ctx)
+}
+
+func (st *fromJsState) fromJsValue(ctx *C.duk_context) (goVal interface{}, err error) {
 	var length C.size_t
 
 	switch C.duk_get_type(ctx, -1) {
-	case C.DUK_TYPE_UNDEFINED, C.DUK_TYPE_NULL, C.DUK_TYPE_NONE:
+	case C.DUK_TYPE_UNDEFINED, C.DUK_TYPE_NONE:
+		if isStrictConverting(ctx) {
+			goVal = Undefined
+		}
+		return
+	case C.DUK_TYPE_NULL:
+		if isStrictConverting(ctx) {
+			goVal = Null
+		}
 		return
 	case C.DUK_TYPE_BOOLEAN:
 		goVal = uint32(C.duk_get_boolean(ctx, -1)) != 0
@@ -27,52 +118,124 @@ func fromJsValue(ctx *C.duk_context) (goVal interface{}, err error) {
 		return
 	case C.DUK_TYPE_STRING:
 		s := C.duk_get_lstring(ctx, -1, &length)
-		goVal = *(toString(s, int(length)))
+		if isBorrowing(ctx) {
+			goVal = *(toString(s, int(length)))
+		} else {
+			goVal = C.GoStringN(s, C.int(length)) // owned by Go, the string may be freed by duktape
+		}
 		return
 	case C.DUK_TYPE_BUFFER:
-		b := (*C.char)(C.duk_get_buffer(ctx, -1, &length))
-		goVal = toBytes(b, int(length))
+		b := C.duk_get_buffer(ctx, -1, &length)
+		goVal = fromBuffer(ctx, b, length)
 		return
 	case C.DUK_TYPE_OBJECT:
 		switch {
 		case C.duk_is_function(ctx, -1) != 0:
-			goVal = fromJsFunc(ctx)
-			return
+			// Go function, or JS function, bound function, C function
+			var isProxy bool
+			if goVal, isProxy = getGoValue(ctx, -1); isProxy {
+				return
+			}
+			return fromJsCallable(ctx)
 		case C.duk_is_buffer_data(ctx, -1) != 0:
-			b := (*C.char)(C.duk_get_buffer_data(ctx, -1, &length))
-			goVal = toBytes(b, int(length))
+			goVal = fromBufferObj(ctx)
 			return
 		case C.duk_get_error_code(ctx, -1) != 0:
 			s := C.duk_safe_to_lstring(ctx, -1, &length)
-			err = fmt.Errorf(*(toString(s, int(length))))
+			err = fmt.Errorf("%s", C.GoStringN(s, C.int(length)))
 			return
 		case C.duk_is_array(ctx, -1) != 0:
 			// array
-			return fromJsArr(ctx)
-		case C.duk_is_c_function(ctx, -1) != 0:
-			// c function
-			return fromCFunc(ctx)
+			return st.fromJsArr(ctx)
 		default:
 			// object
-			return fromJsObj(ctx)
+			return st.fromJsObj(ctx)
 		}
 	case C.DUK_TYPE_POINTER:
 		goVal = unsafe.Pointer(C.duk_get_pointer(ctx, -1))
 		return
-	// case C.DUK_TYPE_LIGHTFUNC:
+	case C.DUK_TYPE_LIGHTFUNC:
+		// a lightfunc has no properties, it is coerced to a function object to be bound.
+		C.duk_to_object(ctx, -1)
+		return fromJsCallable(ctx)
 	default:
 		err = fmt.Errorf("unsupporting type")
 		return
 	}
 }
 
-func fromJsArr(ctx *C.duk_context) (goVal interface{}, err error) {
+func fromJsCallable(ctx *C.duk_context) (goVal interface{}, err error) {
+	fn, e := fromJsFunc(ctx)
+	if e != nil {
+		err = e
+		return
+	}
+	goVal = fn
+	return
+}
+
+func fromBuffer(ctx *C.duk_context, b unsafe.Pointer, length C.size_t) []byte {
+	if isBorrowing(ctx) {
+		return toBytes((*C.char)(b), int(length))
+	}
+	return C.GoBytes(b, C.int(length)) // owned by Go, the buffer may be freed by duktape
+}
+
+// enter checks the depth and cycles before converting the object on the top of the stack.
+func (st *fromJsState) enter(ctx *C.duk_context) (ptr unsafe.Pointer, ref interface{}, isRef bool, err error) {
+	if st.depth >= st.maxDepth {
+		err = fmt.Errorf("object nested deeper than %d levels", st.maxDepth)
+		return
+	}
+	if C.duk_check_stack(ctx, 8) == 0 {
+		err = fmt.Errorf("object nested too deeply")
+		return
+	}
+	ptr = C.duk_get_heapptr(ctx, -1)
+	if ref, isRef = st.visiting[ptr]; isRef {
+		if st.cycleMode != CycleAsReference {
+			err = fmt.Errorf("cyclic object value")
+		}
+		return
+	}
+	st.depth += 1
+	return
+}
+
+func (st *fromJsState) visit(ptr unsafe.Pointer, goVal interface{}) {
+	if st.visiting == nil {
+		st.visiting = make(map[unsafe.Pointer]interface{})
+	}
+	st.visiting[ptr] = goVal
+}
+
+func (st *fromJsState) leave(ptr unsafe.Pointer) {
+	delete(st.visiting, ptr)
+	st.depth -= 1
+}
+
+func (st *fromJsState) addElements(n int) (err error) {
+	st.elements += n
+	if st.maxElements > 0 && st.elements > st.maxElements {
+		err = fmt.Errorf("more than %d elements", st.maxElements)
+	}
+	return
+}
+
+func (st *fromJsState) fromJsArr(ctx *C.duk_context) (goVal interface{}, err error) {
 	// [ ... arr ]
 	var isProxy bool
-	if goVal, isProxy = getTargetValue(ctx, -1); isProxy {
+	if goVal, isProxy = getGoValue(ctx, -1); isProxy {
 		return
 	}
 
+	ptr, ref, isRef, e := st.enter(ctx)
+	if e != nil || isRef {
+		goVal, err = ref, e
+		return
+	}
+	defer st.leave(ptr)
+
 	l := C.duk_get_length(ctx, -1)
 	if l == 0 {
 		goVal = []interface{}{}
@@ -80,18 +243,24 @@ func fromJsArr(ctx *C.duk_context) (goVal interface{}, err error) {
 	}
 
 	length := int(l)
+	if err = st.addElements(length); err != nil {
+		return
+	}
 	res := make([]interface{}, length)
+	st.visit(ptr, res)
 	for i:=0; i<length; i++ {
-		C.duk_get_prop_index(ctx, -1, C.duk_uarridx_t(i)) // [ ... arr i-th-value ]
-		val, e := fromJsValue(ctx)
+		C.duk_push_uint(ctx, C.duk_uint_t(i)) // [ ... arr i ]
+		if C.safeGetProp(ctx, -2) != 0 {     // [ ... arr i-th-value/error ]
+			err = fmt.Errorf("%s", C.GoString(C.getCString(ctx, -1)))
+			C.duk_pop(ctx)
+			return
+		}
+		val, e := st.fromJsValue(ctx)
 		if e != nil {
 			err = e
 			C.duk_pop(ctx)
 			return
 		}
-		if _, ok := val.(string); ok {
-			val = fmt.Sprintf("%s", val) // deep copy
-		}
 		res[i] = val
 		C.duk_pop(ctx) // [ ... arr ]
 	}
@@ -99,41 +268,80 @@ func fromJsArr(ctx *C.duk_context) (goVal interface{}, err error) {
 	return
 }
 
-func fromJsObj(ctx *C.duk_context) (goVal interface{}, err error) {
+func (st *fromJsState) fromJsObj(ctx *C.duk_context) (goVal interface{}, err error) {
 	// [ ... obj ]
 	var isProxy bool
-	if goVal, isProxy = getTargetValue(ctx, -1); isProxy {
+	if goVal, isProxy = getGoValue(ctx, -1); isProxy {
+		return
+	}
+	var isInt64, isDate bool
+	if goVal, isInt64 = fromInt64Obj(ctx); isInt64 {
+		return
+	}
+	if goVal, isDate = fromDateObj(ctx); isDate {
 		return
 	}
 
-	C.duk_enum(ctx, -1, 0) // [ ... obj enum ]
+	ptr, ref, isRef, e := st.enter(ctx)
+	if e != nil || isRef {
+		goVal, err = ref, e
+		return
+	}
+	defer st.leave(ptr)
+
+	switch objClass(ctx) {
+	case "RegExp":
+		goVal = fromRegExpObj(ctx)
+		return
+	case "Map":
+		return st.fromMapObj(ctx, ptr)
+	case "Set":
+		return st.fromSetObj(ctx, ptr)
+	}
+
+	var enumFlags C.duk_uint_t
+	if st.symbolKeys {
+		enumFlags |= C.DUK_ENUM_INCLUDE_SYMBOLS
+	}
+	C.duk_enum(ctx, -1, enumFlags) // [ ... obj enum ]
+	defer C.duk_pop(ctx)           // [ ... obj ]
+
 	res := make(map[string]interface{})
-	for C.duk_next(ctx, -1, 1) != 0 {
-		// [ ... obj enum key value ]
-		key := C.GoString(C.getCString(ctx, -2))
-		val, e := fromJsValue(ctx)
+	st.visit(ptr, res)
+	for C.duk_next(ctx, -1, 0) != 0 {
+		// [ ... obj enum key ]
+		if st.ignoreGetters && C.isAccessor(ctx, -3) != 0 {
+			C.duk_pop(ctx) // [ ... obj enum ]
+			continue
+		}
+		if err = st.addElements(1); err != nil {
+			C.duk_pop(ctx)
+			return
+		}
+		key := propKey(ctx)
+		if C.safeGetProp(ctx, -3) != 0 { // [ ... obj enum value/error ]
+			err = fmt.Errorf("%s", C.GoString(C.getCString(ctx, -1)))
+			C.duk_pop(ctx)
+			return
+		}
+		val, e := st.fromJsValue(ctx)
+		C.duk_pop(ctx) // [ ... obj enum ]
 		if e != nil {
 			err = e
-			C.duk_pop_n(ctx, 3) // [ ... obj ]
 			return
 		}
-		if _, ok := val.(string); ok {
-			val = fmt.Sprintf("%s", val) // deep copy
-		}
 		res[key] = val
-		C.duk_pop_n(ctx, 2) // [ ... obj enum ]
 	}
-	C.duk_pop(ctx) // [ ... obj ]
 	goVal = res
 	return
 }
 
-func fromCFunc(ctx *C.duk_context) (goVal interface{}, err error) {
-	// [ ... c-function ]
-	var isProxy bool
-	if goVal, isProxy = getTargetValue(ctx, -1); isProxy {
-		return
+// propKey returns the property key on the top of the stack, a Symbol key is rendered as "Symbol(description)".
+func propKey(ctx *C.duk_context) string {
+	if C.duk_is_symbol(ctx, -1) == 0 {
+		return C.GoString(C.getCString(ctx, -1))
 	}
-	err = fmt.Errorf("cannot process such c-function")
-	return
+	C.pushSymbolString(ctx, -1) // [ ... key str ]
+	defer C.duk_pop(ctx)        // [ ... key ]
+	return C.GoString(C.getCString(ctx, -1))
 }